@@ -3,6 +3,8 @@ package collector
 import (
 	"context"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/codex-team/hawk.collector/pkg/accounts"
 
@@ -27,27 +29,8 @@ type RunCommand struct {
 
 // Execute Run server - Load configuration file and start server
 func (x *RunCommand) Execute(args []string) error {
-	if err := godotenv.Load(); err != nil {
-		log.Println("File .env not found, reading configuration from ENV")
-	}
-
-	// load config from .env
-	var cfg cmd.Config
-	if err := env.Parse(&cfg); err != nil {
-		log.Fatalf("Failed to parse ENV")
-	}
-
-	// setup logging and set log level from config
-	level, err := log.ParseLevel(cfg.LogLevel)
-	if err != nil {
-		level = log.ErrorLevel
-	}
-	log.SetFormatter(&log.TextFormatter{
-		FullTimestamp: true,
-	})
-	log.SetOutput(os.Stdout)
-	log.SetLevel(level)
-	log.Infof("✓ Log level set on %s", level)
+	cfg := loadConfig()
+	var err error
 
 	// Initialize Hawk Catcher
 	if cfg.HawkEnabled {
@@ -68,24 +51,18 @@ func (x *RunCommand) Execute(args []string) error {
 	log.Infof("✓ Broker initialized on %s", cfg.BrokerURL)
 
 	// connect to Redis
-	log.Infof("Connecting to Redis %s", cfg.RedisURL)
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-
-	redisClient := redis.New(ctx,
-		cfg.RedisURL,
-		cfg.RedisPassword,
-		cfg.RedisDisabledProjectsSet,
-		cfg.RedisBlacklistIPsSet,
-		cfg.RedisAllIPsMap,
-		cfg.RedisCurrentPeriodMap,
-	)
+	redisClient := connectRedis(ctx, cfg)
 
 	err = redisClient.LoadBlockedIDs()
 	if err != nil {
 		log.Errorf("failed to load blocked IDs from Redis")
 	}
+	if cfg.BlockedIDsWatch {
+		go redisClient.WatchBlockedIDs(cfg.BlockedIDsChannel)
+	}
 
 	// connect to accounts MongoDB
 	doneAccountsContext := make(chan struct{})
@@ -108,12 +85,51 @@ func (x *RunCommand) Execute(args []string) error {
 	// start HTTP and websocket server
 	serverObj := server.New(cfg, brokerObj, redisClient, accountsClient, cfg.BlacklistThreshold, cfg.NotifyURL)
 
+	// caps are computed on startup so projects are protected before the first update period
+	err = serverObj.SpikeProtection.Update()
+	if err != nil {
+		log.Errorf("failed to update spike protection caps: %s", err)
+	}
+
 	done := make(chan struct{})
 	go periodic.RunPeriodically(redisClient.LoadBlockedIDs, cfg.BlockedIDsLoad, done)
+	go periodic.RunPeriodically(redisClient.FlushIPCounts, cfg.IPCountsFlushPeriod, done)
+	go periodic.RunPeriodically(serverObj.Metrics.Flush, cfg.MetricsFlushPeriod, done)
 	go periodic.RunPeriodically(serverObj.UpdateBlacklist, cfg.BlacklistUpdatePeriod, done)
+	go periodic.RunPeriodically(serverObj.SpikeProtection.Update, cfg.SpikeProtectionUpdatePeriod, done)
+	if cfg.RateLimitLeaseTTL > 0 {
+		go periodic.RunPeriodically(serverObj.RateLimiter.Cleanup, cfg.RateLimitLeaseTTL, done)
+	}
+	if serverObj.IPLimiter != nil {
+		go periodic.RunPeriodically(serverObj.IPLimiter.Cleanup, cfg.IPRateLimitPeriod, done)
+	}
+	if serverObj.Deduplicator != nil {
+		go periodic.RunPeriodically(serverObj.Deduplicator.Flush, cfg.DedupWindow, done)
+	}
 	defer close(done)
 	log.Info("✓ Redis client initialized")
 
+	// repeat counts, metrics and requests counted since the last flush are written on shutdown
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+
+		log.Info("Shutting down")
+		if serverObj.Deduplicator != nil {
+			if err := serverObj.Deduplicator.Close(); err != nil {
+				log.Errorf("failed to release deduplicated events on shutdown: %s", err)
+			}
+		}
+		if err := serverObj.Metrics.Flush(); err != nil {
+			log.Errorf("failed to flush metrics on shutdown: %s", err)
+		}
+		if err := redisClient.FlushIPCounts(); err != nil {
+			log.Errorf("failed to flush IP counts on shutdown: %s", err)
+		}
+		os.Exit(0)
+	}()
+
 	// listen and serve prometheus metrics
 	go metrics.RunServer(cfg.MetricsListen)
 
@@ -121,3 +137,60 @@ func (x *RunCommand) Execute(args []string) error {
 
 	return nil
 }
+
+// loadConfig loads configuration from .env file or ENV and sets up logging
+func loadConfig() cmd.Config {
+	if err := godotenv.Load(); err != nil {
+		log.Println("File .env not found, reading configuration from ENV")
+	}
+
+	// load config from .env
+	var cfg cmd.Config
+	if err := env.Parse(&cfg); err != nil {
+		log.Fatalf("Failed to parse ENV")
+	}
+
+	// setup logging and set log level from config
+	level, err := log.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		level = log.ErrorLevel
+	}
+	log.SetFormatter(&log.TextFormatter{
+		FullTimestamp: true,
+	})
+	log.SetOutput(os.Stdout)
+	log.SetLevel(level)
+	log.Infof("✓ Log level set on %s", level)
+
+	return cfg
+}
+
+// connectRedis creates Redis client from configuration
+func connectRedis(ctx context.Context, cfg cmd.Config) *redis.RedisClient {
+	connection := redis.Connection{
+		URL:          cfg.RedisURL,
+		Username:     cfg.RedisUsername,
+		Password:     cfg.RedisPassword,
+		DB:           cfg.RedisDB,
+		TLSCAFile:    cfg.RedisTLSCAFile,
+		PoolSize:     cfg.RedisPoolSize,
+		DialTimeout:  cfg.RedisDialTimeout,
+		ReadTimeout:  cfg.RedisReadTimeout,
+		WriteTimeout: cfg.RedisWriteTimeout,
+	}
+	log.Infof("Connecting to Redis %s", connection.Redacted())
+
+	redisClient, err := redis.New(ctx,
+		connection,
+		cfg.RedisDisabledProjectsSet,
+		cfg.RedisBlacklistIPsSet,
+		cfg.RedisAllowlistIPsSet,
+		cfg.RedisBlacklistExpiryMap,
+		cfg.RedisAllIPsMap,
+		cfg.RedisCurrentPeriodMap,
+	)
+	if err != nil {
+		log.Fatalf("Invalid Redis connection settings: %s", err)
+	}
+	return redisClient
+}
@@ -0,0 +1,42 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/codex-team/hawk.collector/pkg/redis"
+	"github.com/codex-team/hawk.collector/pkg/tsmetrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// MigrateMetricsCommand - Create compaction rules of existing metrics series
+type MigrateMetricsCommand struct{}
+
+// Execute creation of compaction rules from minutely metrics series into hourly and daily ones
+func (x *MigrateMetricsCommand) Execute(args []string) error {
+	cfg := loadConfig()
+	redisClient := connectRedis(context.Background(), cfg)
+	if redisClient.IsCluster() {
+		return fmt.Errorf("metrics compaction is not supported by Redis Cluster")
+	}
+
+	retention := tsmetrics.Retention{
+		Minutely: cfg.MetricsMinutelyRetention,
+		Hourly:   cfg.MetricsHourlyRetention,
+		Daily:    cfg.MetricsDailyRetention,
+	}
+
+	// keys of project and workspace metrics differ only in the granularity suffix
+	rulesOf := func(key string) []redis.TSRule {
+		prefix := strings.TrimSuffix(key, ":minutely")
+		return retention.Compacted(key, prefix+":hourly", prefix+":daily", nil).Rules
+	}
+
+	created, err := redisClient.MigrateTSCompaction("ts:*:minutely", rulesOf)
+	if err != nil {
+		return err
+	}
+	log.Infof("✓ Created %d metrics compaction rules", created)
+	return nil
+}
@@ -0,0 +1,67 @@
+package collector
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/codex-team/hawk.collector/pkg/accounts"
+	"github.com/codex-team/hawk.collector/pkg/server/releasehandler"
+	log "github.com/sirupsen/logrus"
+)
+
+// MigrateRateLimitsCommand - Copy rate limits state from the legacy hash into per-counter keys
+type MigrateRateLimitsCommand struct {
+	TTL time.Duration `long:"ttl" default:"24h" description:"Expiry of migrated counters, refreshed by the next event of the counter"`
+}
+
+// Execute migration of rate limits state, groups of legacy counters are resolved via the accounts database
+func (x *MigrateRateLimitsCommand) Execute(args []string) error {
+	cfg := loadConfig()
+	redisClient := connectRedis(context.Background(), cfg)
+
+	accountsClient := accounts.New(cfg.AccountsMongoDBURI)
+	if err := accountsClient.UpdateProjectsLimitsCache(); err != nil {
+		return err
+	}
+
+	// groups must match the ones used by errors and release handlers
+	groupOf := func(counterID string) string {
+		if strings.HasPrefix(counterID, "workspace:") || strings.HasSuffix(counterID, ":"+releasehandler.ReleaseCategory) {
+			return counterID
+		}
+
+		projectID := strings.SplitN(counterID, ":", 2)[0]
+		if workspaceID, _, ok := accountsClient.GetWorkspaceLimits(projectID); ok {
+			return "workspace:" + workspaceID
+		}
+		return projectID
+	}
+
+	migrated, err := redisClient.MigrateRateLimits(groupOf, x.TTL)
+	if err != nil {
+		return err
+	}
+	log.Infof("✓ Migrated %d rate limits counters", migrated)
+	return nil
+}
+
+// CleanupRateLimitsCommand - Delete the legacy rate limits hash after migration
+type CleanupRateLimitsCommand struct{}
+
+// Execute deletion of the legacy rate limits hash
+func (x *CleanupRateLimitsCommand) Execute(args []string) error {
+	cfg := loadConfig()
+	redisClient := connectRedis(context.Background(), cfg)
+
+	deleted, err := redisClient.CleanupLegacyRateLimits()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		log.Infof("Legacy rate limits hash not found")
+		return nil
+	}
+	log.Infof("✓ Deleted legacy rate limits hash")
+	return nil
+}
@@ -25,6 +25,12 @@ type Config struct {
 	// Maximum POST body size in bytes for error messages
 	MaxErrorCatcherMessageSize int `env:"MAX_ERROR_CATCHER_MESSAGE_SIZE"`
 
+	// Keep events over MaxErrorCatcherMessageSize trimming their largest fields instead of rejecting them
+	TruncateOversizedEvents bool `env:"TRUNCATE_OVERSIZED_EVENTS" envDefault:"false"`
+
+	// Maximum POST body size in bytes for error messages which are truncated if TruncateOversizedEvents is set
+	MaxTruncatedMessageSize int `env:"MAX_TRUNCATED_MESSAGE_SIZE" envDefault:"1000000"`
+
 	// Maximum POST body size in bytes for release messages
 	MaxReleaseCatcherMessageSize int `env:"MAX_RELEASE_CATCHER_MESSAGE_SIZE"`
 
@@ -40,8 +46,20 @@ type Config struct {
 	// Whether to enable Hawk Catcher
 	HawkEnabled bool `env:"HAWK_ENABLED" defaultEnv:"false"`
 
+	// Redis address or redis://, rediss://, redis+sentinel:// or redis+cluster:// URL, values of the URL override other settings
 	RedisURL      string `env:"REDIS_URL"`
+	RedisUsername string `env:"REDIS_USERNAME"`
 	RedisPassword string `env:"REDIS_PASSWORD"`
+	RedisDB       int    `env:"REDIS_DB" envDefault:"0"`
+
+	// PEM file with CA certificates to verify Redis TLS connections, empty value means system roots
+	RedisTLSCAFile string `env:"REDIS_TLS_CA_FILE"`
+
+	// Maximum number of connections per Redis node, 0 means 10 per CPU
+	RedisPoolSize     int           `env:"REDIS_POOL_SIZE" envDefault:"0"`
+	RedisDialTimeout  time.Duration `env:"REDIS_DIAL_TIMEOUT" envDefault:"5s"`
+	RedisReadTimeout  time.Duration `env:"REDIS_READ_TIMEOUT" envDefault:"3s"`
+	RedisWriteTimeout time.Duration `env:"REDIS_WRITE_TIMEOUT" envDefault:"3s"`
 
 	// MongoDB connection URI to the accounts database
 	AccountsMongoDBURI         string        `env:"ACCOUNTS_MONGODB_URI"`
@@ -49,11 +67,114 @@ type Config struct {
 	ProjectsLimitsUpdatePeriod time.Duration `env:"PROJECTS_LIMITS_UPDATE_PERIOD" defaultEnv:"1m"`
 	RedisDisabledProjectsSet   string        `env:"REDIS_DISABLED_PROJECT_SET"`
 	RedisBlacklistIPsSet       string        `env:"REDIS_BLACKLIST_IP_SET"`
+	RedisAllowlistIPsSet       string        `env:"REDIS_ALLOWLIST_IP_SET"`
+	RedisBlacklistExpiryMap    string        `env:"REDIS_BLACKLIST_EXPIRY_MAP"`
 	RedisAllIPsMap             string        `env:"REDIS_ALL_IPS_MAP"`
 	RedisCurrentPeriodMap      string        `env:"REDIS_CURRENT_PERIOD_MAP"`
 
 	BlockedIDsLoad time.Duration `env:"BLOCKED_PROJECTS_UPDATE_PERIOD"`
 
+	// Subscribe to blocked projects changes to update the cache without waiting for the periodic reload
+	BlockedIDsWatch   bool   `env:"BLOCKED_PROJECTS_WATCH" envDefault:"true"`
+	BlockedIDsChannel string `env:"REDIS_BLOCKED_PROJECTS_CHANNEL"`
+
+	// Number of events reserved from Redis rate limits at once by the local limiter (1 disables leasing)
+	RateLimitLeaseSize int64 `env:"RATE_LIMIT_LEASE_SIZE" envDefault:"1"`
+
+	// Maximum time the local limiter trusts a lease or a rejection without asking Redis
+	RateLimitLeaseTTL time.Duration `env:"RATE_LIMIT_LEASE_TTL" envDefault:"1s"`
+
+	// Time interval to recompute spike protection baselines
+	SpikeProtectionUpdatePeriod time.Duration `env:"SPIKE_PROTECTION_UPDATE_PERIOD" envDefault:"10m"`
+
+	// Number of complete hours used to compute the baseline of a project
+	SpikeProtectionBaselineHours int `env:"SPIKE_PROTECTION_BASELINE_HOURS" envDefault:"24"`
+
+	// Minimal baseline of a project (events per hour)
+	SpikeProtectionMinBaseline int64 `env:"SPIKE_PROTECTION_MIN_BASELINE" envDefault:"100"`
+
+	// Time window duplicates of an event are suppressed in, 0 disables deduplication
+	DedupWindow time.Duration `env:"DEDUP_WINDOW" envDefault:"10s"`
+
+	// Maximum number of event fingerprints kept in memory for deduplication
+	DedupCacheSize int `env:"DEDUP_CACHE_SIZE" envDefault:"10000"`
+
+	// Fingerprint gjson paths per catcher type, e.g. "errors/javascript=title,backtrace.#.file;errors/python=title"
+	DedupFingerprintPaths []string `env:"DEDUP_FINGERPRINT_PATHS" envSeparator:";"`
+
+	// Directory with JSON Schemas of payloads per catcher type, e.g. errors/javascript.json, empty disables validation
+	CatcherSchemasDir string `env:"CATCHER_SCHEMAS_DIR"`
+
+	// Schema validation mode: "enforce" rejects invalid payloads, "warn" only records violations
+	SchemaValidationMode string `env:"SCHEMA_VALIDATION_MODE" envDefault:"enforce"`
+
+	// Attach client IP, parsed User-Agent and GeoIP location to events
+	EnrichmentEnabled bool `env:"ENRICHMENT_ENABLED" envDefault:"true"`
+
+	// Path to GeoIP database in MaxMind format (GeoLite2-City.mmdb), empty disables GeoIP
+	GeoIPDatabasePath string `env:"GEOIP_DATABASE_PATH"`
+
+	// Tolerance for event timestamps in the future
+	MaxFutureTimestamp time.Duration `env:"MAX_FUTURE_TIMESTAMP" envDefault:"1m"`
+
+	// Reject events with timestamps more than MaxFutureTimestamp in the future instead of clamping them
+	RejectFutureTimestamps bool `env:"REJECT_FUTURE_TIMESTAMPS" envDefault:"false"`
+
+	// Delay after which the event is considered buffered by the client
+	BufferedEventThreshold time.Duration `env:"BUFFERED_EVENT_THRESHOLD" envDefault:"5m"`
+
+	// IPs and CIDR prefixes of proxies trusted to set TrustedProxyHeader and PROXY protocol header
+	TrustedProxies []string `env:"TRUSTED_PROXIES" envSeparator:","`
+
+	// The only forwarding header set by trusted proxies: Forwarded, X-Forwarded-For or X-Real-IP
+	TrustedProxyHeader string `env:"TRUSTED_PROXY_HEADER" envDefault:"X-Forwarded-For"`
+
+	// Accept PROXY protocol v1/v2 header from trusted proxies, e.g. behind L4 load balancers
+	ProxyProtocol bool `env:"PROXY_PROTOCOL" envDefault:"false"`
+
+	// Requests of a client IP allowed per IPRateLimitPeriod (0 disables the limit)
+	IPRateLimit       int64         `env:"IP_RATE_LIMIT" envDefault:"0"`
+	IPRateLimitPeriod time.Duration `env:"IP_RATE_LIMIT_PERIOD" envDefault:"1m"`
+
+	// Requests with invalid integration tokens of a client IP allowed per IPInvalidTokenPeriod (0 disables the limit)
+	IPInvalidTokenLimit  int64         `env:"IP_INVALID_TOKEN_LIMIT" envDefault:"0"`
+	IPInvalidTokenPeriod time.Duration `env:"IP_INVALID_TOKEN_PERIOD" envDefault:"1m"`
+
+	// Time a client IP is blocked for after exhausting the invalid token budget, doubled for each repeated exhaustion
+	IPInvalidTokenPenalty time.Duration `env:"IP_INVALID_TOKEN_PENALTY" envDefault:"1m"`
+
+	// Share per-IP budgets between collector instances via Redis
+	IPRateLimitShared bool `env:"IP_RATE_LIMIT_SHARED" envDefault:"false"`
+
+	// Temporarily ban IPs exceeding BlacklistThreshold instead of only sending alerts
+	AutoBanEnabled bool `env:"AUTO_BAN_ENABLED" envDefault:"false"`
+
+	// Duration of the first ban, doubled for each repeat offence
+	AutoBanDuration time.Duration `env:"AUTO_BAN_DURATION" envDefault:"1h"`
+
+	// Maximum duration of escalated bans
+	AutoBanMaxDuration time.Duration `env:"AUTO_BAN_MAX_DURATION" envDefault:"168h"`
+
+	// Time previous bans of the IP are taken into account for escalation
+	AutoBanOffenceMemory time.Duration `env:"AUTO_BAN_OFFENCE_MEMORY" envDefault:"720h"`
+
+	// IPs and CIDR prefixes of our own infrastructure which are never banned automatically
+	AutoBanAllowlist []string `env:"AUTO_BAN_ALLOWLIST" envSeparator:","`
+
+	// Time interval to flush requests counted per IP to Redis
+	IPCountsFlushPeriod time.Duration `env:"IP_COUNTS_FLUSH_PERIOD" envDefault:"2s"`
+
+	// Time interval to write project and workspace metrics accumulated in memory to Redis TimeSeries
+	MetricsFlushPeriod time.Duration `env:"METRICS_FLUSH_PERIOD" envDefault:"1s"`
+
+	// Retention of minutely, hourly and daily metrics series
+	MetricsMinutelyRetention time.Duration `env:"METRICS_MINUTELY_RETENTION" envDefault:"24h"`
+	MetricsHourlyRetention   time.Duration `env:"METRICS_HOURLY_RETENTION" envDefault:"168h"`
+	MetricsDailyRetention    time.Duration `env:"METRICS_DAILY_RETENTION" envDefault:"2160h"`
+
+	// Write only minutely metrics series and compute hourly and daily ones by RedisTimeSeries compaction rules
+	MetricsCompaction bool `env:"METRICS_COMPACTION" envDefault:"false"`
+
 	BlacklistUpdatePeriod time.Duration `env:"BLACKLIST_UPDATE_PERIOD"`
 	BlacklistThreshold    int           `env:"BLACKLIST_THRESHOLD"`
 	NonDefaultQueues      []string      `env:"NON_DEFAULT_QUEUES" envSeparator:","`
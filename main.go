@@ -8,7 +8,10 @@ import (
 
 // Command-line interface options
 var opts struct {
-	Run collector.RunCommand `command:"run" description:"Run server"` // nolint: unused
+	Run               collector.RunCommand               `command:"run" description:"Run server"`                                                     // nolint: unused
+	MigrateRateLimits collector.MigrateRateLimitsCommand `command:"migrate-rate-limits" description:"Copy rate limits state from the legacy hash"`    // nolint: unused
+	CleanupRateLimits collector.CleanupRateLimitsCommand `command:"cleanup-rate-limits" description:"Delete the legacy rate limits hash"`             // nolint: unused
+	MigrateMetrics    collector.MigrateMetricsCommand    `command:"migrate-metrics" description:"Create compaction rules of existing metrics series"` // nolint: unused
 }
 
 func main() {
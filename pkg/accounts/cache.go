@@ -7,6 +7,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/codex-team/hawk.collector/pkg/filters"
+	"github.com/codex-team/hawk.collector/pkg/scrubber"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -29,16 +31,108 @@ type accountProject struct {
 	Token             string             `bson:"token"`
 	WorkspaceID       primitive.ObjectID `bson:"workspaceId"`
 	RateLimitSettings rateLimitSettings  `bson:"rateLimitSettings"`
+
+	// SpikeProtectionDisabled opts the project out of spike protection
+	SpikeProtectionDisabled bool `bson:"spikeProtectionDisabled"`
+
+	Sampling samplingSettings `bson:"sampling"`
+
+	// Deduplication enables suppression of duplicate events within a short window
+	Deduplication bool `bson:"deduplication"`
+
+	// Filters drop unwanted events before they consume the quota
+	Filters filters.Settings `bson:"filters"`
+
+	// Scrubbing redacts personal data of events before they are sent to the queue
+	Scrubbing scrubber.Settings `bson:"scrubbing"`
+
+	// StoreIP allows the collector to attach the client IP to events
+	StoreIP bool `bson:"storeIp"`
+}
+
+// samplingSettings describes the share of project events kept by the collector
+type samplingSettings struct {
+	// Rate is the share of kept events in (0, 1), 0 or values >= 1 keep all events
+	Rate float64 `bson:"rate"`
+
+	// CatcherTypes overrides the rate for catcher types, e.g. "errors/javascript" or "external/sentry"
+	CatcherTypes map[string]float64 `bson:"catcherTypes"`
+
+	// Fingerprint enables deterministic sampling by payload fingerprint,
+	// so duplicates of the same error are either all kept or all sampled out
+	Fingerprint bool `bson:"fingerprint"`
+}
+
+// RateFor returns the share of kept events of the catcher type, 1 means no sampling
+func (settings samplingSettings) RateFor(catcherType string) float64 {
+	rate := settings.Rate
+	if catcherRate, ok := settings.CatcherTypes[catcherType]; ok {
+		rate = catcherRate
+	}
+	if rate <= 0 || rate >= 1 {
+		return 1
+	}
+	return rate
 }
 
 type rateLimitSettings struct {
 	EventsLimit  int64 `bson:"N"`
 	EventsPeriod int64 `bson:"T"`
+
+	// Algorithm is one of "fixed-window" (default), "sliding-window" or "token-bucket"
+	Algorithm string `bson:"algorithm"`
+
+	// Categories contains separate limits for catcher types (e.g. "errors/javascript"),
+	// Sentry item categories (e.g. "sentry/transaction") and release uploads ("release")
+	Categories map[string]rateLimitSettings `bson:"categories"`
+}
+
+// merge returns settings overridden by non-empty values of other settings
+func (settings rateLimitSettings) merge(other rateLimitSettings) rateLimitSettings {
+	result := settings
+	if other.EventsLimit > 0 {
+		result.EventsLimit = other.EventsLimit
+	}
+	if other.EventsPeriod > 0 {
+		result.EventsPeriod = other.EventsPeriod
+	}
+	if other.Algorithm != "" {
+		result.Algorithm = other.Algorithm
+	}
+
+	if len(other.Categories) > 0 {
+		result.Categories = make(map[string]rateLimitSettings, len(settings.Categories)+len(other.Categories))
+		for category, limits := range settings.Categories {
+			result.Categories[category] = limits
+		}
+		for category, limits := range other.Categories {
+			result.Categories[category] = result.Categories[category].merge(limits)
+		}
+	}
+
+	return result
+}
+
+// ForCategory returns limits for the category (catcher type, Sentry item category or release uploads).
+// Unset values are taken from the project limits. Returns false if the category has no own limits
+// and should share the project counter.
+func (settings rateLimitSettings) ForCategory(category string) (rateLimitSettings, bool) {
+	categoryLimits, ok := settings.Categories[category]
+	if !ok {
+		return settings, false
+	}
+
+	result := settings.merge(categoryLimits)
+	result.Categories = nil
+	return result, true
 }
 
 type tariffPlan struct {
 	PlanID            primitive.ObjectID `bson:"_id"`
 	RateLimitSettings rateLimitSettings  `bson:"rateLimitSettings"`
+
+	// SpikeProtectionMultiplier caps intake at the multiple of the project hourly baseline, 0 disables spike protection
+	SpikeProtectionMultiplier float64 `bson:"spikeProtectionMultiplier"`
 }
 
 type accountWorkspace struct {
@@ -145,16 +239,29 @@ func (client *AccountsMongoDBClient) UpdateProjectsLimitsCache() error {
 		plan      tariffPlan
 	}
 	workspaceMap := make(map[string]workspaceWithPlan, len(workspaces))
+
+	// Workspace quota is shared by all workspace projects: plan limits overridden by workspace ones
+	workspaceLimitsTmp := make(map[string]rateLimitSettings, len(workspaces))
 	for _, workspace := range workspaces {
 		plan, ok := plansMap[workspace.TariffPlanID]
 		if !ok {
 			continue
 		}
-		workspaceMap[workspace.WorkspaceID.Hex()] = workspaceWithPlan{workspace: workspace, plan: plan}
+		workspaceID := workspace.WorkspaceID.Hex()
+		workspaceMap[workspaceID] = workspaceWithPlan{workspace: workspace, plan: plan}
+
+		workspaceLimitsTmp[workspaceID] = plan.RateLimitSettings.merge(workspace.RateLimitSettings)
 	}
 
-	// Create a temporary map instead of directly modifying client.projectLimits
+	// Create temporary maps instead of directly modifying client.projectLimits and client.projectWorkspaces
 	projectLimitsTmp := make(map[string]rateLimitSettings)
+	projectWorkspacesTmp := make(map[string]string)
+	spikeMultipliersTmp := make(map[string]float64)
+	projectSamplingTmp := make(map[string]samplingSettings)
+	projectDeduplicationTmp := make(map[string]bool)
+	projectFiltersTmp := make(map[string]*filters.Filter)
+	projectScrubbersTmp := make(map[string]*scrubber.Scrubber)
+	projectStoreIPTmp := make(map[string]bool)
 
 	// Process each project applying the priority rules
 	for _, project := range projects {
@@ -164,31 +271,53 @@ func (client *AccountsMongoDBClient) UpdateProjectsLimitsCache() error {
 		log.Tracef("Project with id %s and limits %+v", projectID, project.RateLimitSettings)
 
 		if entry, exists := workspaceMap[project.WorkspaceID.Hex()]; exists {
-			finalLimits = entry.plan.RateLimitSettings
+			projectWorkspacesTmp[projectID] = project.WorkspaceID.Hex()
+			finalLimits = entry.plan.RateLimitSettings.merge(entry.workspace.RateLimitSettings)
 
-			if entry.workspace.RateLimitSettings.EventsLimit > 0 {
-				finalLimits.EventsLimit = entry.workspace.RateLimitSettings.EventsLimit
-			}
-			if entry.workspace.RateLimitSettings.EventsPeriod > 0 {
-				finalLimits.EventsPeriod = entry.workspace.RateLimitSettings.EventsPeriod
+			if entry.plan.SpikeProtectionMultiplier > 0 && !project.SpikeProtectionDisabled {
+				spikeMultipliersTmp[projectID] = entry.plan.SpikeProtectionMultiplier
 			}
 		}
 
-		if project.RateLimitSettings.EventsLimit > 0 {
-			finalLimits.EventsLimit = project.RateLimitSettings.EventsLimit
-		}
-		if project.RateLimitSettings.EventsPeriod > 0 {
-			finalLimits.EventsPeriod = project.RateLimitSettings.EventsPeriod
-		}
+		finalLimits = finalLimits.merge(project.RateLimitSettings)
 
 		// Add to temporary map instead of client.projectLimits
 		projectLimitsTmp[projectID] = finalLimits
+
+		if project.Sampling.Rate > 0 || len(project.Sampling.CatcherTypes) > 0 {
+			projectSamplingTmp[projectID] = project.Sampling
+		}
+
+		if project.Deduplication {
+			projectDeduplicationTmp[projectID] = true
+		}
+
+		if project.Filters.Enabled() {
+			projectFiltersTmp[projectID] = filters.New(project.Filters)
+		}
+
+		if project.Scrubbing.Configured() {
+			projectScrubbersTmp[projectID] = scrubber.New(project.Scrubbing)
+		}
+
+		if project.StoreIP {
+			projectStoreIPTmp[projectID] = true
+		}
 	}
 
-	// Atomically replace the map reference
+	// Atomically replace the map references
 	client.projectLimits = projectLimitsTmp
+	client.projectWorkspaces = projectWorkspacesTmp
+	client.workspaceLimits = workspaceLimitsTmp
+	client.spikeMultipliers = spikeMultipliersTmp
+	client.projectSampling = projectSamplingTmp
+	client.projectDeduplication = projectDeduplicationTmp
+	client.projectFilters = projectFiltersTmp
+	client.projectScrubbers = projectScrubbersTmp
+	client.projectStoreIP = projectStoreIPTmp
 
 	log.Tracef("Current projects limits cache state: %+v", client.projectLimits)
+	log.Tracef("Current workspaces limits cache state: %+v", client.workspaceLimits)
 
 	return nil
 }
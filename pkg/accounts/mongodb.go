@@ -6,6 +6,9 @@ import (
 	"path"
 	"time"
 
+	"github.com/codex-team/hawk.collector/pkg/filters"
+	"github.com/codex-team/hawk.collector/pkg/scrubber"
+
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -23,6 +26,30 @@ type AccountsMongoDBClient struct {
 	database      string
 	validTokens   map[string]string
 	projectLimits map[string]rateLimitSettings
+
+	// projectWorkspaces maps project ID to its workspace ID
+	projectWorkspaces map[string]string
+
+	// workspaceLimits contains quotas shared by all projects of the workspace
+	workspaceLimits map[string]rateLimitSettings
+
+	// spikeMultipliers contains spike protection multipliers of projects which are protected
+	spikeMultipliers map[string]float64
+
+	// projectSampling contains sampling settings of projects which keep only a share of events
+	projectSampling map[string]samplingSettings
+
+	// projectDeduplication contains projects which opted in to deduplication of events
+	projectDeduplication map[string]bool
+
+	// projectFilters contains compiled inbound filters of projects
+	projectFilters map[string]*filters.Filter
+
+	// projectScrubbers contains compiled PII scrubbing rules of projects
+	projectScrubbers map[string]*scrubber.Scrubber
+
+	// projectStoreIP contains projects which allow storing client IPs
+	projectStoreIP map[string]bool
 }
 
 func New(connectionURI string) *AccountsMongoDBClient {
@@ -53,6 +80,12 @@ func New(connectionURI string) *AccountsMongoDBClient {
 	}
 }
 
+// NewWithTokens creates client without MongoDB connection serving the provided cache
+// of integration secrets to project IDs, e.g. in tests
+func NewWithTokens(validTokens map[string]string) *AccountsMongoDBClient {
+	return &AccountsMongoDBClient{validTokens: validTokens}
+}
+
 // CheckAvailability checks if mongodb is available
 func (m *AccountsMongoDBClient) CheckAvailability() bool {
 	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
@@ -72,3 +105,47 @@ func (client *AccountsMongoDBClient) GetProjectLimits(projectID string) (rateLim
 	limits, ok := client.projectLimits[projectID]
 	return limits, ok
 }
+
+// GetWorkspaceLimits returns the workspace ID of a project and the quota shared by all workspace projects
+func (client *AccountsMongoDBClient) GetWorkspaceLimits(projectID string) (string, rateLimitSettings, bool) {
+	workspaceID, ok := client.projectWorkspaces[projectID]
+	if !ok {
+		return "", rateLimitSettings{}, false
+	}
+	limits, ok := client.workspaceLimits[workspaceID]
+	return workspaceID, limits, ok
+}
+
+// GetSpikeProtectedProjects returns spike protection multipliers of all protected projects.
+// The map must not be modified.
+func (client *AccountsMongoDBClient) GetSpikeProtectedProjects() map[string]float64 {
+	return client.spikeMultipliers
+}
+
+// GetProjectSampling returns sampling settings of a project, false if all events of the project are kept
+func (client *AccountsMongoDBClient) GetProjectSampling(projectID string) (samplingSettings, bool) {
+	sampling, ok := client.projectSampling[projectID]
+	return sampling, ok
+}
+
+// IsDeduplicationEnabled returns true if the project opted in to deduplication of events
+func (client *AccountsMongoDBClient) IsDeduplicationEnabled(projectID string) bool {
+	return client.projectDeduplication[projectID]
+}
+
+// GetProjectFilter returns inbound filter of a project, false if the project has no filters
+func (client *AccountsMongoDBClient) GetProjectFilter(projectID string) (*filters.Filter, bool) {
+	filter, ok := client.projectFilters[projectID]
+	return filter, ok
+}
+
+// GetProjectScrubber returns PII scrubber of a project, false if payloads of the project are not scrubbed
+func (client *AccountsMongoDBClient) GetProjectScrubber(projectID string) (*scrubber.Scrubber, bool) {
+	projectScrubber, ok := client.projectScrubbers[projectID]
+	return projectScrubber, ok
+}
+
+// IsIPStored returns true if the project allows storing client IPs
+func (client *AccountsMongoDBClient) IsIPStored(projectID string) bool {
+	return client.projectStoreIP[projectID]
+}
@@ -0,0 +1,134 @@
+package autoban
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/codex-team/hawk.collector/pkg/alerts"
+	"github.com/codex-team/hawk.collector/pkg/clientip"
+	"github.com/codex-team/hawk.collector/pkg/iptrie"
+	"github.com/codex-team/hawk.collector/pkg/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+// Banner temporarily bans IPs which exceeded the requests threshold.
+// Repeat offenders are banned for twice as long as the previous time.
+type Banner struct {
+	redisClient *redis.RedisClient
+
+	// Duration is the ban duration of the first offence
+	Duration time.Duration
+
+	// MaxDuration caps escalated ban durations
+	MaxDuration time.Duration
+
+	// OffenceMemory is the time previous bans of the IP are taken into account for escalation
+	OffenceMemory time.Duration
+
+	// NotifyURL is the address to send ban and unban alerts
+	NotifyURL string
+
+	// infrastructure IPs which are never banned in addition to the Redis allowlist
+	allowlist *iptrie.Trie
+}
+
+// New creates banner never banning IPs and CIDR prefixes from the allowlist
+func New(redisClient *redis.RedisClient, duration, maxDuration, offenceMemory time.Duration, allowlist []string, notifyURL string) (*Banner, error) {
+	banner := &Banner{
+		redisClient:   redisClient,
+		Duration:      duration,
+		MaxDuration:   maxDuration,
+		OffenceMemory: offenceMemory,
+		NotifyURL:     notifyURL,
+		allowlist:     iptrie.New(),
+	}
+
+	for _, entry := range allowlist {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		network, err := clientip.ParseNetwork(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid auto ban allowlist entry %q: %w", entry, err)
+		}
+		banner.allowlist.Insert(network, time.Time{})
+	}
+
+	return banner, nil
+}
+
+// banDuration returns the ban duration of the offence number, starting with 1
+func banDuration(base, max time.Duration, offence int64) time.Duration {
+	duration := base
+	for i := int64(1); i < offence && (max <= 0 || duration < max); i++ {
+		duration *= 2
+	}
+	if max > 0 && duration > max {
+		return max
+	}
+	return duration
+}
+
+// IsAllowed returns true if the IP must never be banned
+func (b *Banner) IsAllowed(ip string) bool {
+	if parsed := net.ParseIP(ip); parsed != nil && b.allowlist.Contains(parsed, time.Now()) {
+		return true
+	}
+	return b.redisClient.IsAllowlisted(ip)
+}
+
+// Ban bans the IP which sent the provided number of requests for the last period.
+// Returns false if the IP is allowlisted.
+func (b *Banner) Ban(ip string, requests int) (bool, error) {
+	if b.IsAllowed(ip) {
+		log.WithFields(log.Fields{"audit": "ip-ban-skipped", "ip": ip, "requests": requests}).
+			Warnf("IP %s exceeded the threshold with %d requests but is allowlisted", ip, requests)
+		return false, nil
+	}
+
+	offence, err := b.redisClient.IncrementOffences(ip, b.OffenceMemory)
+	if err != nil {
+		return false, err
+	}
+
+	duration := banDuration(b.Duration, b.MaxDuration, offence)
+	until := time.Now().Add(duration)
+	if err := b.redisClient.BanIP(ip, until); err != nil {
+		return false, err
+	}
+
+	log.WithFields(log.Fields{
+		"audit":    "ip-ban",
+		"ip":       ip,
+		"requests": requests,
+		"offence":  offence,
+		"duration": duration.String(),
+		"until":    until.UTC().Format(time.RFC3339),
+	}).Warnf("IP %s banned for %s after %d requests (offence %d)", ip, duration, requests, offence)
+	b.notify(fmt.Sprintf("Hawk Collector ⛔️\n\nIP %s banned for %s after %d requests (offence %d)", ip, duration, requests, offence))
+
+	return true, nil
+}
+
+// Unbanned records the IP removed from the blacklist after its ban expired
+func (b *Banner) Unbanned(ip string) {
+	log.WithFields(log.Fields{"audit": "ip-unban", "ip": ip}).Infof("IP %s unbanned after the ban expired", ip)
+	b.notify(fmt.Sprintf("Hawk Collector ✅\n\nIP %s unbanned after the ban expired", ip))
+}
+
+func (b *Banner) notify(message string) {
+	if b.NotifyURL == "" {
+		return
+	}
+
+	go func() {
+		err := alerts.Notify(b.NotifyURL, message)
+		if err != nil {
+			log.Errorf("failed to send IP ban alert: %s", err)
+		}
+	}()
+}
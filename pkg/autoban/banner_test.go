@@ -0,0 +1,52 @@
+package autoban
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/codex-team/hawk.collector/pkg/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBanDuration(t *testing.T) {
+	assert.Equal(t, time.Hour, banDuration(time.Hour, 24*time.Hour, 1))
+	assert.Equal(t, 2*time.Hour, banDuration(time.Hour, 24*time.Hour, 2))
+	assert.Equal(t, 8*time.Hour, banDuration(time.Hour, 24*time.Hour, 4))
+	assert.Equal(t, 24*time.Hour, banDuration(time.Hour, 24*time.Hour, 6))
+	assert.Equal(t, 24*time.Hour, banDuration(time.Hour, 24*time.Hour, 100))
+}
+
+func TestBan(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	client, err := redis.New(context.Background(), redis.Connection{URL: mr.Addr()}, "", "blacklist", "allowlist", "expiry", "", "current")
+	require.NoError(t, err)
+	banner, err := New(client, time.Hour, 24*time.Hour, 30*24*time.Hour, []string{"10.0.0.0/8"}, "")
+	require.NoError(t, err)
+
+	banned, err := banner.Ban("10.1.2.3", 100000)
+	require.NoError(t, err)
+	assert.False(t, banned)
+	assert.False(t, client.CheckBlacklist("10.1.2.3"))
+
+	banned, err = banner.Ban("203.0.113.7", 100000)
+	require.NoError(t, err)
+	assert.True(t, banned)
+	assert.True(t, client.CheckBlacklist("203.0.113.7"))
+
+	isMember, _ := mr.SIsMember("blacklist", "203.0.113.7")
+	assert.True(t, isMember)
+	first := mr.HGet("expiry", "203.0.113.7")
+
+	// repeat offender is banned for longer
+	_, err = banner.Ban("203.0.113.7", 100000)
+	require.NoError(t, err)
+	assert.Greater(t, mr.HGet("expiry", "203.0.113.7"), first)
+	offences, _ := mr.Get("ip-offences:203.0.113.7")
+	assert.Equal(t, "2", offences)
+}
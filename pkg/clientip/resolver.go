@@ -0,0 +1,151 @@
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Forwarding headers which may be set by trusted proxies
+const (
+	Forwarded     = "Forwarded"
+	XForwardedFor = "X-Forwarded-For"
+	XRealIP       = "X-Real-IP"
+)
+
+// UserValueKey is the user value of the request context with the client IP resolved by the server
+const UserValueKey = "remoteIP"
+
+// Resolver determines the client IP taking into account the header set by trusted proxies only,
+// so clients cannot spoof their address by sending forwarding headers directly
+type Resolver struct {
+	trusted []*net.IPNet
+
+	// Header is the only forwarding header set by trusted proxies, other headers are passed through from clients
+	Header string
+}
+
+// New creates resolver trusting proxies from the list of IPs and CIDR prefixes
+// to set the header: Forwarded, X-Forwarded-For or X-Real-IP
+func New(trustedProxies []string, header string) (*Resolver, error) {
+	resolver := &Resolver{}
+	for _, name := range []string{Forwarded, XForwardedFor, XRealIP} {
+		if strings.EqualFold(header, name) {
+			resolver.Header = name
+		}
+	}
+	if resolver.Header == "" {
+		return nil, fmt.Errorf("unknown trusted proxy header %q", header)
+	}
+
+	for _, entry := range trustedProxies {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		network, err := ParseNetwork(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		resolver.trusted = append(resolver.trusted, network)
+	}
+	return resolver, nil
+}
+
+// ParseNetwork parses CIDR prefix or a single IP as a network
+func ParseNetwork(entry string) (*net.IPNet, error) {
+	if strings.Contains(entry, "/") {
+		_, network, err := net.ParseCIDR(entry)
+		return network, err
+	}
+
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address")
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// IsTrusted returns true if the address belongs to a trusted proxy
+func (resolver *Resolver) IsTrusted(ip net.IP) bool {
+	for _, network := range resolver.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the client IP of the request received from remote with value of the resolver Header.
+// The header is taken into account only if remote is a trusted proxy. The chain of Forwarded or X-Forwarded-For header
+// is walked right-to-left skipping trusted proxies, X-Real-IP is used as is.
+func (resolver *Resolver) Resolve(remote net.IP, value string) net.IP {
+	if remote == nil || !resolver.IsTrusted(remote) {
+		return remote
+	}
+
+	var chain []string
+	switch resolver.Header {
+	case Forwarded:
+		chain = parseForwarded(value)
+	case XForwardedFor:
+		if value != "" {
+			chain = strings.Split(value, ",")
+		}
+	case XRealIP:
+		if ip := parseAddress(value); ip != nil {
+			return ip
+		}
+	}
+
+	if len(chain) == 0 {
+		return remote
+	}
+
+	client := remote
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := parseAddress(chain[i])
+		if ip == nil {
+			// unknown or obfuscated hop, the last trusted address is the closest known one
+			break
+		}
+		client = ip
+		if !resolver.IsTrusted(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// parseForwarded returns "for" parameters of RFC 7239 Forwarded header elements
+func parseForwarded(header string) []string {
+	var chain []string
+	for _, element := range strings.Split(header, ",") {
+		for _, pair := range strings.Split(element, ";") {
+			parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(parts) == 2 && strings.EqualFold(parts[0], "for") {
+				chain = append(chain, strings.Trim(parts[1], `"`))
+			}
+		}
+	}
+	return chain
+}
+
+// parseAddress parses IP with optional port and IPv6 brackets, nil if the address is invalid
+func parseAddress(address string) net.IP {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return nil
+	}
+	if ip := net.ParseIP(address); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return net.ParseIP(host)
+	}
+	return net.ParseIP(strings.Trim(address, "[]"))
+}
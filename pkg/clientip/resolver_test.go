@@ -0,0 +1,68 @@
+package clientip
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	trusted := []string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"}
+
+	tests := []struct {
+		name    string
+		header  string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{name: "direct client", header: XForwardedFor, remote: "203.0.113.7", want: "203.0.113.7"},
+		{name: "spoofed header from untrusted client", header: XForwardedFor, remote: "203.0.113.7", headers: map[string]string{XForwardedFor: "1.2.3.4"}, want: "203.0.113.7"},
+		{name: "single proxy", header: XForwardedFor, remote: "10.0.0.1", headers: map[string]string{XForwardedFor: "203.0.113.7"}, want: "203.0.113.7"},
+		{name: "spoofed chain behind proxies", header: XForwardedFor, remote: "10.0.0.1", headers: map[string]string{XForwardedFor: "1.2.3.4, 203.0.113.7, 10.0.0.2"}, want: "203.0.113.7"},
+		{name: "all hops trusted", header: XForwardedFor, remote: "10.0.0.1", headers: map[string]string{XForwardedFor: "10.0.0.3, 10.0.0.2"}, want: "10.0.0.3"},
+		{name: "invalid hop", header: XForwardedFor, remote: "10.0.0.1", headers: map[string]string{XForwardedFor: "203.0.113.7, garbage, 10.0.0.2"}, want: "10.0.0.2"},
+		{name: "spoofed forwarded through proxy setting x-forwarded-for", header: XForwardedFor, remote: "10.0.0.1", headers: map[string]string{Forwarded: "for=1.2.3.4", XForwardedFor: "203.0.113.7"}, want: "203.0.113.7"},
+		{name: "spoofed real ip through proxy setting x-forwarded-for", header: XForwardedFor, remote: "10.0.0.1", headers: map[string]string{XRealIP: "1.2.3.4"}, want: "10.0.0.1"},
+		{name: "real ip", header: XRealIP, remote: "192.0.2.1", headers: map[string]string{XRealIP: "203.0.113.7", XForwardedFor: "1.2.3.4"}, want: "203.0.113.7"},
+		{name: "forwarded header", header: Forwarded, remote: "10.0.0.1", headers: map[string]string{Forwarded: `for=1.2.3.4, for="[2001:db8:cafe::17]:4711";proto=https, for=198.51.100.3:80`, XForwardedFor: "5.6.7.8"}, want: "198.51.100.3"},
+		{name: "forwarded ipv6", header: Forwarded, remote: "2001:db8::1", headers: map[string]string{Forwarded: `for="[2001:db9::17]:4711"`}, want: "2001:db9::17"},
+		{name: "obfuscated forwarded", header: Forwarded, remote: "10.0.0.1", headers: map[string]string{Forwarded: `for=_hidden`}, want: "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver, err := New(trusted, tt.header)
+			require.NoError(t, err)
+
+			ip := resolver.Resolve(net.ParseIP(tt.remote), tt.headers[resolver.Header])
+			assert.Equal(t, tt.want, ip.String())
+		})
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	_, err := New([]string{"10.0.0.0/33"}, XForwardedFor)
+	assert.Error(t, err)
+
+	_, err = New([]string{"proxy"}, XForwardedFor)
+	assert.Error(t, err)
+
+	_, err = New([]string{"10.0.0.0/8"}, "X-Client-IP")
+	assert.Error(t, err)
+
+	resolver, err := New(nil, "x-real-ip")
+	require.NoError(t, err)
+	assert.Equal(t, XRealIP, resolver.Header)
+}
+
+func TestIsTrustedTrimsEntries(t *testing.T) {
+	resolver, err := New([]string{" 10.0.0.0/8", "", " 127.0.0.1 "}, XForwardedFor)
+	require.NoError(t, err)
+
+	assert.True(t, resolver.IsTrusted(net.ParseIP("10.1.2.3")))
+	assert.True(t, resolver.IsTrusted(net.ParseIP("127.0.0.1")))
+	assert.False(t, resolver.IsTrusted(net.ParseIP("192.0.2.1")))
+}
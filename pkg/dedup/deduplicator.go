@@ -0,0 +1,133 @@
+package dedup
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Entry is the first event with the fingerprint seen in the window
+type Entry struct {
+	// Key is the fingerprint of the event
+	Key string
+
+	// Value is the event passed to Check
+	Value interface{}
+
+	// Repeats is the number of suppressed duplicates
+	Repeats int64
+
+	// LastSeen is the time of the last suppressed duplicate
+	LastSeen time.Time
+
+	expiresAt time.Time
+}
+
+// Deduplicator suppresses duplicates of events within a time window using an in-memory LRU cache.
+// The first event is passed through, suppressed duplicates are released as a single entry with the repeat count
+// when the window expires or the entry is evicted from the cache.
+type Deduplicator struct {
+	mx sync.Mutex
+
+	// Window is the time duplicates are suppressed after the first event
+	Window time.Duration
+
+	// Size is the maximum number of fingerprints kept in memory
+	Size int
+
+	// release is called for entries with suppressed duplicates
+	release func(*Entry)
+
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// New creates deduplicator which calls release for every window with suppressed duplicates
+func New(window time.Duration, size int, release func(*Entry)) *Deduplicator {
+	return &Deduplicator{
+		Window:  window,
+		Size:    size,
+		release: release,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Check returns true if the event with the key is a duplicate and should be suppressed,
+// otherwise remembers value as the first event of the window
+func (d *Deduplicator) Check(key string, value interface{}) bool {
+	now := time.Now()
+	var released []*Entry
+
+	d.mx.Lock()
+	if element, ok := d.entries[key]; ok {
+		entry := element.Value.(*Entry)
+		if now.Before(entry.expiresAt) {
+			entry.Repeats++
+			entry.LastSeen = now
+			d.order.MoveToFront(element)
+			d.mx.Unlock()
+			return true
+		}
+		released = d.remove(element, released)
+	}
+
+	d.entries[key] = d.order.PushFront(&Entry{Key: key, Value: value, expiresAt: now.Add(d.Window)})
+	for d.order.Len() > d.Size {
+		released = d.remove(d.order.Back(), released)
+	}
+	d.mx.Unlock()
+
+	d.releaseAll(released)
+	return false
+}
+
+// Flush releases expired entries, should be run periodically
+func (d *Deduplicator) Flush() error {
+	now := time.Now()
+	var released []*Entry
+
+	d.mx.Lock()
+	for element := d.order.Back(); element != nil; {
+		previous := element.Prev()
+		if !now.Before(element.Value.(*Entry).expiresAt) {
+			released = d.remove(element, released)
+		}
+		element = previous
+	}
+	d.mx.Unlock()
+
+	d.releaseAll(released)
+	return nil
+}
+
+// Close releases all entries with suppressed duplicates regardless of the window, should be called on shutdown
+func (d *Deduplicator) Close() error {
+	var released []*Entry
+
+	d.mx.Lock()
+	for element := d.order.Back(); element != nil; element = d.order.Back() {
+		released = d.remove(element, released)
+	}
+	d.mx.Unlock()
+
+	d.releaseAll(released)
+	return nil
+}
+
+// remove deletes the element from the cache and appends it to released if it has suppressed duplicates
+func (d *Deduplicator) remove(element *list.Element, released []*Entry) []*Entry {
+	entry := element.Value.(*Entry)
+	d.order.Remove(element)
+	delete(d.entries, entry.Key)
+	if entry.Repeats > 0 {
+		released = append(released, entry)
+	}
+	return released
+}
+
+func (d *Deduplicator) releaseAll(released []*Entry) {
+	for _, entry := range released {
+		d.release(entry)
+	}
+}
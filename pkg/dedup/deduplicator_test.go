@@ -0,0 +1,64 @@
+package dedup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeduplicatorWindow(t *testing.T) {
+	var released []*Entry
+	d := New(50*time.Millisecond, 10, func(entry *Entry) { released = append(released, entry) })
+
+	assert.False(t, d.Check("a", "first"))
+	assert.True(t, d.Check("a", "second"))
+	assert.True(t, d.Check("a", "third"))
+	assert.False(t, d.Check("b", "other"))
+
+	assert.NoError(t, d.Flush())
+	assert.Empty(t, released)
+
+	time.Sleep(60 * time.Millisecond)
+	assert.NoError(t, d.Flush())
+	assert.Len(t, released, 1)
+	assert.Equal(t, "first", released[0].Value)
+	assert.Equal(t, int64(2), released[0].Repeats)
+
+	assert.False(t, d.Check("a", "next window"))
+}
+
+func TestDeduplicatorEviction(t *testing.T) {
+	var released []*Entry
+	d := New(time.Minute, 2, func(entry *Entry) { released = append(released, entry) })
+
+	d.Check("a", 1)
+	d.Check("a", 1)
+	d.Check("b", 2)
+	d.Check("a", 1)
+	d.Check("c", 3)
+
+	// b is the least recently used entry and has no duplicates
+	assert.Empty(t, released)
+
+	d.Check("d", 4)
+	assert.Len(t, released, 1)
+	assert.Equal(t, "a", released[0].Key)
+	assert.Equal(t, int64(2), released[0].Repeats)
+}
+
+func TestDeduplicatorClose(t *testing.T) {
+	var released []*Entry
+	d := New(time.Minute, 10, func(entry *Entry) { released = append(released, entry) })
+
+	d.Check("a", 1)
+	d.Check("a", 1)
+	d.Check("b", 2)
+
+	assert.NoError(t, d.Close())
+	assert.Len(t, released, 1)
+	assert.Equal(t, "a", released[0].Key)
+	assert.Equal(t, int64(1), released[0].Repeats)
+
+	assert.False(t, d.Check("a", 1))
+}
@@ -0,0 +1,143 @@
+package enrichment
+
+import (
+	"net"
+
+	"github.com/mssola/useragent"
+	"github.com/oschwald/maxminddb-golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// Context is the data about the client known to the collector
+type Context struct {
+	// IP is the client IP, omitted if the project doesn't store IPs
+	IP string `json:"ip,omitempty"`
+
+	UserAgent *UserAgent `json:"userAgent,omitempty"`
+	Geo       *Geo       `json:"geo,omitempty"`
+}
+
+// UserAgent is the parsed User-Agent header
+type UserAgent struct {
+	Browser        string `json:"browser,omitempty"`
+	BrowserVersion string `json:"browserVersion,omitempty"`
+	OS             string `json:"os,omitempty"`
+	OSVersion      string `json:"osVersion,omitempty"`
+
+	// Device is "desktop", "mobile" or "bot"
+	Device string `json:"device"`
+}
+
+// Geo is the location of the client IP
+type Geo struct {
+	Country     string `json:"country,omitempty"`
+	CountryCode string `json:"countryCode,omitempty"`
+	City        string `json:"city,omitempty"`
+}
+
+// geoRecord is the subset of MaxMind GeoIP2/GeoLite2 City and Country databases record
+type geoRecord struct {
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+// Enricher builds the client context from the request
+type Enricher struct {
+	geoIP *maxminddb.Reader
+}
+
+// New creates enricher with GeoIP database in MaxMind format, empty path disables GeoIP
+func New(geoIPPath string) (*Enricher, error) {
+	enricher := &Enricher{}
+	if geoIPPath == "" {
+		return enricher, nil
+	}
+
+	reader, err := maxminddb.Open(geoIPPath)
+	if err != nil {
+		return nil, err
+	}
+	enricher.geoIP = reader
+	log.Infof("✓ GeoIP database %s loaded", geoIPPath)
+
+	return enricher, nil
+}
+
+// Context returns the client context, IP is included only if storeIP is set.
+// Returns nil if nothing is known about the client.
+func (enricher *Enricher) Context(ip, userAgent string, storeIP bool) *Context {
+	if enricher == nil {
+		return nil
+	}
+
+	context := &Context{
+		UserAgent: parseUserAgent(userAgent),
+		Geo:       enricher.lookup(ip),
+	}
+	if storeIP {
+		context.IP = ip
+	}
+
+	if context.IP == "" && context.UserAgent == nil && context.Geo == nil {
+		return nil
+	}
+	return context
+}
+
+// parseUserAgent returns browser, OS and device of the User-Agent
+func parseUserAgent(header string) *UserAgent {
+	if header == "" {
+		return nil
+	}
+
+	parsed := useragent.New(header)
+	browser, browserVersion := parsed.Browser()
+	os := parsed.OSInfo()
+
+	device := "desktop"
+	if parsed.Bot() {
+		device = "bot"
+	} else if parsed.Mobile() {
+		device = "mobile"
+	}
+
+	return &UserAgent{
+		Browser:        browser,
+		BrowserVersion: browserVersion,
+		OS:             os.Name,
+		OSVersion:      os.Version,
+		Device:         device,
+	}
+}
+
+// lookup returns the location of the IP, nil if GeoIP is disabled or the IP is unknown
+func (enricher *Enricher) lookup(ip string) *Geo {
+	if enricher.geoIP == nil || ip == "" {
+		return nil
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil
+	}
+
+	var record geoRecord
+	if err := enricher.geoIP.Lookup(parsed, &record); err != nil {
+		log.Warnf("GeoIP lookup of %s failed: %s", ip, err)
+		return nil
+	}
+	if record.Country.ISOCode == "" {
+		return nil
+	}
+
+	return &Geo{
+		Country:     record.Country.Names["en"],
+		CountryCode: record.Country.ISOCode,
+		City:        record.City.Names["en"],
+	}
+}
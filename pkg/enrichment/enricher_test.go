@@ -0,0 +1,31 @@
+package enrichment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContext(t *testing.T) {
+	enricher, err := New("")
+	require.NoError(t, err)
+
+	context := enricher.Context("203.0.113.7", "Mozilla/5.0 (iPhone; CPU iPhone OS 16_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.0 Mobile/15E148 Safari/604.1", true)
+	require.NotNil(t, context)
+	assert.Equal(t, "203.0.113.7", context.IP)
+	assert.Equal(t, "Safari", context.UserAgent.Browser)
+	assert.Equal(t, "16.0", context.UserAgent.BrowserVersion)
+	assert.Equal(t, "mobile", context.UserAgent.Device)
+	assert.Nil(t, context.Geo)
+
+	context = enricher.Context("203.0.113.7", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", false)
+	require.NotNil(t, context)
+	assert.Empty(t, context.IP)
+	assert.Equal(t, "bot", context.UserAgent.Device)
+
+	assert.Nil(t, enricher.Context("203.0.113.7", "", false))
+
+	var disabled *Enricher
+	assert.Nil(t, disabled.Context("203.0.113.7", "curl/8.0", true))
+}
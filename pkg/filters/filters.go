@@ -0,0 +1,158 @@
+package filters
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Reasons of filtered events
+const (
+	BrowserExtensions = "browser-extensions"
+	Localhost         = "localhost"
+	WebCrawlers       = "web-crawlers"
+	LegacyBrowsers    = "legacy-browsers"
+	Releases          = "releases"
+	ErrorMessages     = "error-messages"
+)
+
+// extensionSchemes are URL schemes of scripts injected by browser extensions
+var extensionSchemes = []string{"chrome-extension://", "moz-extension://", "safari-extension://", "safari-web-extension://", "ms-browser-extension://"}
+
+// extensionMessages are typical errors caused by browser extensions and injected scripts
+var extensionMessages = regexp.MustCompile(`(top\.GLOBALS|originalCreateNotification|canvas\.contentDocument|MyApp_RemoveAllHighlights|atomicFindClose|conduitPage|__gCrWeb)`)
+
+// webCrawlers matches User-Agents of known web crawlers.
+// The bot token is a separate word or a suffix of the crawler name like Googlebot/2.1,
+// so devices like CUBOT and the DuckDuckGo browser are not matched.
+var webCrawlers = regexp.MustCompile(`(?i)(\bbot\b|[a-z]bot[/-]|crawler|spider|slurp|mediapartners-google|yandex|baiduspider|facebookexternalhit|bingpreview|ia_archiver|lighthouse|pingdom|uptimerobot|headlesschrome)`)
+
+// legacyBrowsers matches User-Agents of browsers which are not supported anymore
+var legacyBrowsers = regexp.MustCompile(`(MSIE [0-9]+\.|Trident/|Presto/|Opera Mini/|Android [1-3]\.)`)
+
+// Settings is the filters configuration stored in the project document
+type Settings struct {
+	// BrowserExtensions ignores errors thrown by browser extensions
+	BrowserExtensions bool `bson:"browserExtensions"`
+
+	// Localhost ignores events sent from localhost origins
+	Localhost bool `bson:"localhost"`
+
+	// WebCrawlers ignores events sent by known web crawlers
+	WebCrawlers bool `bson:"webCrawlers"`
+
+	// LegacyBrowsers ignores events sent by legacy browsers (Internet Explorer, Opera Presto, old Android)
+	LegacyBrowsers bool `bson:"legacyBrowsers"`
+
+	// Releases ignores events of release versions matching glob patterns
+	Releases []string `bson:"releases"`
+
+	// ErrorMessages ignores events with title or message matching regular expressions
+	ErrorMessages []string `bson:"errorMessages"`
+}
+
+// Enabled returns true if any of the filters is configured
+func (settings Settings) Enabled() bool {
+	return settings.BrowserExtensions || settings.Localhost || settings.WebCrawlers || settings.LegacyBrowsers ||
+		len(settings.Releases) > 0 || len(settings.ErrorMessages) > 0
+}
+
+// Filter decides whether events of a project should be dropped
+type Filter struct {
+	Settings
+
+	errorMessages []*regexp.Regexp
+}
+
+// Event contains event fields checked by filters
+type Event struct {
+	// Messages are the title and error messages of the event
+	Messages []string
+
+	// Files are file names of stack frames
+	Files []string
+
+	Release   string
+	UserAgent string
+
+	// URL is the page or origin the event is sent from
+	URL string
+}
+
+// New compiles the filter, invalid regular expressions are skipped
+func New(settings Settings) *Filter {
+	filter := &Filter{Settings: settings}
+	for _, pattern := range settings.ErrorMessages {
+		expression, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Warnf("Invalid error message filter %q: %s", pattern, err)
+			continue
+		}
+		filter.errorMessages = append(filter.errorMessages, expression)
+	}
+	return filter
+}
+
+// Check returns the reason if the event should be filtered out
+func (filter *Filter) Check(event Event) (string, bool) {
+	if filter.BrowserExtensions && isBrowserExtension(event) {
+		return BrowserExtensions, true
+	}
+	if filter.Localhost && isLocalhost(event.URL) {
+		return Localhost, true
+	}
+	if filter.WebCrawlers && event.UserAgent != "" && webCrawlers.MatchString(event.UserAgent) {
+		return WebCrawlers, true
+	}
+	if filter.LegacyBrowsers && event.UserAgent != "" && legacyBrowsers.MatchString(event.UserAgent) {
+		return LegacyBrowsers, true
+	}
+	if event.Release != "" {
+		for _, pattern := range filter.Releases {
+			if matched, _ := path.Match(pattern, event.Release); matched {
+				return Releases, true
+			}
+		}
+	}
+	for _, expression := range filter.errorMessages {
+		for _, message := range event.Messages {
+			if expression.MatchString(message) {
+				return ErrorMessages, true
+			}
+		}
+	}
+	return "", false
+}
+
+// isBrowserExtension returns true if the error is thrown by a script of a browser extension
+func isBrowserExtension(event Event) bool {
+	for _, file := range event.Files {
+		for _, scheme := range extensionSchemes {
+			if strings.HasPrefix(file, scheme) {
+				return true
+			}
+		}
+	}
+	for _, message := range event.Messages {
+		if extensionMessages.MatchString(message) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLocalhost returns true if the URL points to the local machine
+func isLocalhost(rawURL string) bool {
+	if rawURL == "" {
+		return false
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := parsed.Hostname()
+	return host == "localhost" || host == "127.0.0.1" || host == "::1" || host == "0.0.0.0" || strings.HasSuffix(host, ".localhost")
+}
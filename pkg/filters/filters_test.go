@@ -0,0 +1,53 @@
+package filters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterCheck(t *testing.T) {
+	filter := New(Settings{
+		BrowserExtensions: true,
+		Localhost:         true,
+		WebCrawlers:       true,
+		LegacyBrowsers:    true,
+		Releases:          []string{"1.0.*", "beta"},
+		ErrorMessages:     []string{"^Script error\\.?$", "(invalid"},
+	})
+
+	tests := []struct {
+		name   string
+		event  Event
+		reason string
+	}{
+		{"regular event", Event{Messages: []string{"TypeError"}, Files: []string{"https://example.com/app.js"}, Release: "2.0.0", UserAgent: "Mozilla/5.0 (X11; Linux x86_64) Chrome/120.0", URL: "https://example.com/"}, ""},
+		{"extension frame", Event{Files: []string{"https://example.com/app.js", "chrome-extension://abc/content.js"}}, BrowserExtensions},
+		{"localhost origin", Event{URL: "http://localhost:8080/page"}, Localhost},
+		{"loopback origin", Event{URL: "http://127.0.0.1/"}, Localhost},
+		{"crawler", Event{UserAgent: "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"}, WebCrawlers},
+		{"duckduckgo crawler", Event{UserAgent: "DuckDuckBot/1.1; (+http://duckduckgo.com/duckduckbot.html)"}, WebCrawlers},
+		{"slack crawler", Event{UserAgent: "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"}, WebCrawlers},
+		{"duckduckgo browser", Event{UserAgent: "Mozilla/5.0 (Linux; Android 13) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/120.0 Mobile DuckDuckGo/5 Safari/537.36"}, ""},
+		{"cubot phone", Event{UserAgent: "Mozilla/5.0 (Linux; Android 11; CUBOT_X30) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Mobile Safari/537.36"}, ""},
+		{"cubot phone with build", Event{UserAgent: "Mozilla/5.0 (Linux; Android 10; KINGKONG 5 Pro Build/CUBOT) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36"}, ""},
+		{"internet explorer", Event{UserAgent: "Mozilla/5.0 (Windows NT 6.1; Trident/7.0; rv:11.0) like Gecko"}, LegacyBrowsers},
+		{"release pattern", Event{Release: "1.0.3"}, Releases},
+		{"error message", Event{Messages: []string{"Script error."}}, ErrorMessages},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, filtered := filter.Check(tt.event)
+			assert.Equal(t, tt.reason != "", filtered)
+			assert.Equal(t, tt.reason, reason)
+		})
+	}
+}
+
+func TestFilterDisabled(t *testing.T) {
+	assert.False(t, Settings{}.Enabled())
+
+	_, filtered := New(Settings{}).Check(Event{URL: "http://localhost/", Files: []string{"moz-extension://abc/a.js"}, UserAgent: "Googlebot"})
+	assert.False(t, filtered)
+}
@@ -0,0 +1,222 @@
+package iplimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/codex-team/hawk.collector/pkg/ratelimit"
+	"github.com/codex-team/hawk.collector/pkg/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+// Reasons of rejected requests
+const (
+	ReasonRequests     = "requests"
+	ReasonInvalidToken = "invalid-token"
+)
+
+// maxStrikes caps the escalation of penalties to 2^(maxStrikes-1) times the base penalty
+const maxStrikes = 7
+
+var rejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "collector_ip_rate_limited_total",
+	Help: "Total number of requests rejected by per-IP rate limits",
+}, []string{"reason"})
+
+// Budget is a token bucket of Limit tokens refilled evenly during Period, zero Limit means no limit
+type Budget struct {
+	Limit  int64
+	Period time.Duration
+}
+
+// bucket is the in-memory state of a Budget
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// take consumes a token if available, otherwise returns the time until the next token
+func (b *bucket) take(budget Budget, now time.Time) (bool, time.Duration) {
+	rate := float64(budget.Limit) / float64(budget.Period)
+	if b.updatedAt.IsZero() {
+		b.tokens = float64(budget.Limit)
+	} else {
+		b.tokens = math.Min(float64(budget.Limit), b.tokens+float64(now.Sub(b.updatedAt))*rate)
+	}
+	b.updatedAt = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate)
+}
+
+// full returns true if the bucket would be full at now, so its state could be dropped
+func (b *bucket) full(budget Budget, now time.Time) bool {
+	return budget.Limit <= 0 || b.updatedAt.IsZero() || b.tokens+float64(now.Sub(b.updatedAt))*float64(budget.Limit)/float64(budget.Period) >= float64(budget.Limit)
+}
+
+// client is the state of a single IP
+type client struct {
+	requests bucket
+	invalid  bucket
+
+	// strikes is the number of times the invalid token budget was exhausted recently
+	strikes      int
+	blockedUntil time.Time
+}
+
+// Limiter rate limits requests per client IP with separate budgets for all requests and requests with invalid tokens.
+// Exhausting the invalid token budget blocks the IP for a penalty doubled by each repeated exhaustion,
+// so invalid token floods are stopped much faster than legitimate traffic.
+type Limiter struct {
+	mx sync.Mutex
+
+	// Requests is the budget of all requests of an IP
+	Requests Budget
+
+	// InvalidTokens is the budget of requests with invalid integration tokens of an IP
+	InvalidTokens Budget
+
+	// Penalty is the duration an IP is blocked for after exhausting InvalidTokens budget the first time
+	Penalty time.Duration
+
+	// shared keeps buckets in Redis, so the budgets are shared by collector instances. Penalties are local.
+	// It must not lease events, since a lease would be reserved by each IP.
+	shared *ratelimit.Limiter
+
+	clients map[string]*client
+}
+
+// New creates limiter keeping buckets in memory or in Redis if shared is not nil
+func New(requests, invalidTokens Budget, penalty time.Duration, shared *ratelimit.Limiter) *Limiter {
+	return &Limiter{
+		Requests:      requests,
+		InvalidTokens: invalidTokens,
+		Penalty:       penalty,
+		shared:        shared,
+		clients:       make(map[string]*client),
+	}
+}
+
+// Allow counts the request of the IP. Returns false and the time to retry after if the IP is limited.
+func (l *Limiter) Allow(ip string) (bool, time.Duration) {
+	if l == nil || ip == "" {
+		return true, 0
+	}
+	now := time.Now()
+
+	l.mx.Lock()
+	// with shared buckets the state is kept only for IPs sending invalid tokens,
+	// so it doesn't grow with every IP sending requests
+	current, ok := l.clients[ip]
+	if ok && now.Before(current.blockedUntil) {
+		l.mx.Unlock()
+		rejected.WithLabelValues(ReasonInvalidToken).Inc()
+		return false, current.blockedUntil.Sub(now)
+	}
+	if l.Requests.Limit <= 0 {
+		l.mx.Unlock()
+		return true, 0
+	}
+	if l.shared == nil {
+		ok, retryAfter := l.client(ip).requests.take(l.Requests, now)
+		l.mx.Unlock()
+		if !ok {
+			rejected.WithLabelValues(ReasonRequests).Inc()
+		}
+		return ok, retryAfter
+	}
+	l.mx.Unlock()
+
+	ok, retryAfter := l.takeShared("ip-requests:"+ip, l.Requests)
+	if !ok {
+		rejected.WithLabelValues(ReasonRequests).Inc()
+	}
+	return ok, retryAfter
+}
+
+// Invalid counts the request of the IP with invalid integration token.
+// The IP is blocked if it exhausted the invalid tokens budget.
+func (l *Limiter) Invalid(ip string) {
+	if l == nil || ip == "" || l.InvalidTokens.Limit <= 0 {
+		return
+	}
+	now := time.Now()
+
+	ok := true
+	if l.shared != nil {
+		ok, _ = l.takeShared("ip-invalid-tokens:"+ip, l.InvalidTokens)
+	}
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	current := l.client(ip)
+	if l.shared == nil {
+		ok, _ = current.invalid.take(l.InvalidTokens, now)
+	}
+	if ok || now.Before(current.blockedUntil) {
+		return
+	}
+
+	if current.strikes < maxStrikes {
+		current.strikes++
+	}
+	penalty := l.Penalty << uint(current.strikes-1)
+	current.blockedUntil = now.Add(penalty)
+	log.Warnf("IP %s is blocked for %s after exhausting the invalid token budget (strike %d)", ip, penalty, current.strikes)
+}
+
+// Cleanup drops state of IPs which are not limited anymore, should be run periodically
+func (l *Limiter) Cleanup() error {
+	if l == nil {
+		return nil
+	}
+	now := time.Now()
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	for ip, current := range l.clients {
+		// strikes are forgotten once the IP stays within the budget for the longest penalty
+		if current.strikes > 0 && now.Sub(current.blockedUntil) < l.Penalty<<uint(maxStrikes-1) {
+			continue
+		}
+		if current.requests.full(l.Requests, now) && current.invalid.full(l.InvalidTokens, now) {
+			delete(l.clients, ip)
+		}
+	}
+
+	return nil
+}
+
+// client returns the state of the IP, must be called with the lock held
+func (l *Limiter) client(ip string) *client {
+	current, ok := l.clients[ip]
+	if !ok {
+		current = &client{}
+		l.clients[ip] = current
+	}
+	return current
+}
+
+// takeShared consumes a token of the budget in Redis, requests are allowed if Redis is unavailable
+func (l *Limiter) takeShared(key string, budget Budget) (bool, time.Duration) {
+	period := int64(budget.Period / time.Second)
+	if period < 1 {
+		period = 1
+	}
+
+	result, err := l.shared.Allow(key, redis.RateLimit{ID: key, Limit: budget.Limit, Period: period, Algorithm: redis.TokenBucket})
+	if err != nil {
+		log.Errorf("failed to check IP rate limit %s: %s", key, err)
+		return true, 0
+	}
+	if result.Exceeded != -1 {
+		return false, time.Duration(result.Reset) * time.Second
+	}
+	return true, 0
+}
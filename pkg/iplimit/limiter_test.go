@@ -0,0 +1,99 @@
+package iplimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/codex-team/hawk.collector/pkg/ratelimit"
+	"github.com/codex-team/hawk.collector/pkg/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucket(t *testing.T) {
+	budget := Budget{Limit: 2, Period: 10 * time.Second}
+	now := time.Now()
+
+	var b bucket
+	ok, _ := b.take(budget, now)
+	assert.True(t, ok)
+	ok, _ = b.take(budget, now)
+	assert.True(t, ok)
+	ok, retryAfter := b.take(budget, now)
+	assert.False(t, ok)
+	assert.Equal(t, 5*time.Second, retryAfter)
+	assert.False(t, b.full(budget, now))
+
+	ok, _ = b.take(budget, now.Add(5*time.Second))
+	assert.True(t, ok)
+	assert.True(t, b.full(budget, now.Add(15*time.Second)))
+}
+
+func TestAllow(t *testing.T) {
+	limiter := New(Budget{Limit: 3, Period: time.Minute}, Budget{}, time.Minute, nil)
+
+	for i := 0; i < 3; i++ {
+		ok, _ := limiter.Allow("203.0.113.7")
+		assert.True(t, ok)
+	}
+	ok, retryAfter := limiter.Allow("203.0.113.7")
+	assert.False(t, ok)
+	assert.InDelta(t, float64(20*time.Second), float64(retryAfter), float64(time.Second))
+
+	// other IPs have their own budget
+	ok, _ = limiter.Allow("203.0.113.8")
+	assert.True(t, ok)
+
+	var disabled *Limiter
+	ok, _ = disabled.Allow("203.0.113.7")
+	assert.True(t, ok)
+}
+
+func TestInvalidEscalation(t *testing.T) {
+	limiter := New(Budget{}, Budget{Limit: 2, Period: time.Hour}, time.Minute, nil)
+
+	limiter.Invalid("203.0.113.7")
+	limiter.Invalid("203.0.113.7")
+	ok, _ := limiter.Allow("203.0.113.7")
+	assert.True(t, ok)
+
+	limiter.Invalid("203.0.113.7")
+	ok, retryAfter := limiter.Allow("203.0.113.7")
+	assert.False(t, ok)
+	assert.InDelta(t, float64(time.Minute), float64(retryAfter), float64(time.Second))
+
+	// repeated exhaustion after the penalty doubles it
+	limiter.clients["203.0.113.7"].blockedUntil = time.Now()
+	limiter.Invalid("203.0.113.7")
+	_, retryAfter = limiter.Allow("203.0.113.7")
+	assert.InDelta(t, float64(2*time.Minute), float64(retryAfter), float64(time.Second))
+
+	// blocked clients are kept by cleanup
+	require.NoError(t, limiter.Cleanup())
+	assert.Contains(t, limiter.clients, "203.0.113.7")
+}
+
+func TestShared(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	client, err := redis.New(context.Background(), redis.Connection{URL: mr.Addr()}, "", "", "", "", "", "")
+	require.NoError(t, err)
+	first := New(Budget{Limit: 2, Period: time.Minute}, Budget{}, time.Minute, ratelimit.New(client, 1, 0))
+	second := New(Budget{Limit: 2, Period: time.Minute}, Budget{}, time.Minute, ratelimit.New(client, 1, 0))
+
+	ok, _ := first.Allow("203.0.113.7")
+	assert.True(t, ok)
+	ok, _ = second.Allow("203.0.113.7")
+	assert.True(t, ok)
+	ok, retryAfter := first.Allow("203.0.113.7")
+	assert.False(t, ok)
+	assert.Equal(t, 30*time.Second, retryAfter)
+
+	// the state is kept in memory only for IPs sending invalid tokens
+	assert.Empty(t, first.clients)
+	assert.Empty(t, second.clients)
+}
@@ -0,0 +1,104 @@
+package iptrie
+
+import (
+	"net"
+	"time"
+)
+
+// ipv4Offset is the number of bits of IPv4-mapped IPv6 prefix
+const ipv4Offset = 96
+
+// Trie is a binary prefix tree of IPv4 and IPv6 networks with optional expiry.
+// Lookups take O(address length) regardless of the number of networks.
+type Trie struct {
+	root node
+	size int
+}
+
+type node struct {
+	children [2]*node
+
+	// terminal is set if the network ends in this node
+	terminal bool
+
+	// expiresAt is the expiry time of the network, zero value means the network never expires
+	expiresAt time.Time
+}
+
+// New creates an empty trie
+func New() *Trie {
+	return &Trie{}
+}
+
+// Len returns the number of networks in the trie
+func (t *Trie) Len() int {
+	return t.size
+}
+
+// Insert adds the network which expires at expiresAt, zero value means the network never expires.
+// If the network is already added, the latest expiry is kept.
+func (t *Trie) Insert(network *net.IPNet, expiresAt time.Time) {
+	ip, bits := key(network)
+	if ip == nil {
+		return
+	}
+
+	current := &t.root
+	for i := 0; i < bits; i++ {
+		bit := bitAt(ip, i)
+		if current.children[bit] == nil {
+			current.children[bit] = &node{}
+		}
+		current = current.children[bit]
+	}
+
+	if !current.terminal {
+		current.terminal = true
+		current.expiresAt = expiresAt
+		t.size++
+		return
+	}
+	if current.expiresAt.IsZero() || expiresAt.IsZero() {
+		current.expiresAt = time.Time{}
+	} else if expiresAt.After(current.expiresAt) {
+		current.expiresAt = expiresAt
+	}
+}
+
+// Contains returns true if the IP belongs to any network of the trie which is not expired at now
+func (t *Trie) Contains(ip net.IP, now time.Time) bool {
+	ip = ip.To16()
+	if ip == nil {
+		return false
+	}
+
+	current := &t.root
+	for i := 0; ; i++ {
+		if current.terminal && (current.expiresAt.IsZero() || now.Before(current.expiresAt)) {
+			return true
+		}
+		if i == len(ip)*8 {
+			return false
+		}
+		current = current.children[bitAt(ip, i)]
+		if current == nil {
+			return false
+		}
+	}
+}
+
+// key returns 16-byte address of the network and its prefix length in the IPv6 space
+func key(network *net.IPNet) (net.IP, int) {
+	ones, bits := network.Mask.Size()
+	if bits == 0 {
+		return nil, 0
+	}
+	if bits == 32 {
+		ones += ipv4Offset
+	}
+	return network.IP.To16(), ones
+}
+
+func bitAt(ip net.IP, i int) int {
+	return int(ip[i/8]>>(7-uint(i%8))) & 1
+}
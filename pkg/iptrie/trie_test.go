@@ -0,0 +1,59 @@
+package iptrie
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func network(cidr string) *net.IPNet {
+	_, parsed, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+func TestContains(t *testing.T) {
+	now := time.Now()
+	trie := New()
+	trie.Insert(network("203.0.113.0/24"), time.Time{})
+	trie.Insert(network("198.51.100.7/32"), now.Add(time.Hour))
+	trie.Insert(network("192.0.2.0/24"), now.Add(-time.Hour))
+	trie.Insert(network("2001:db8:1::/64"), time.Time{})
+	assert.Equal(t, 4, trie.Len())
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"203.0.113.1", true},
+		{"203.0.114.1", false},
+		{"198.51.100.7", true},
+		{"198.51.100.8", false},
+		{"192.0.2.1", false},
+		{"2001:db8:1::abcd", true},
+		{"2001:db8:2::1", false},
+		{"::ffff:203.0.113.5", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, trie.Contains(net.ParseIP(tt.ip), now), tt.ip)
+	}
+
+	// expired entries are ignored at lookup time
+	assert.False(t, trie.Contains(net.ParseIP("198.51.100.7"), now.Add(2*time.Hour)))
+}
+
+func TestInsertKeepsLatestExpiry(t *testing.T) {
+	now := time.Now()
+	trie := New()
+	trie.Insert(network("203.0.113.0/24"), now.Add(time.Minute))
+	trie.Insert(network("203.0.113.0/24"), now.Add(time.Hour))
+	assert.Equal(t, 1, trie.Len())
+	assert.True(t, trie.Contains(net.ParseIP("203.0.113.1"), now.Add(30*time.Minute)))
+
+	trie.Insert(network("203.0.113.0/24"), time.Time{})
+	assert.True(t, trie.Contains(net.ParseIP("203.0.113.1"), now.Add(24*time.Hour)))
+}
@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/codex-team/hawk.collector/pkg/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+// Limiter is an in-process pre-filter for Redis rate limits.
+// It reserves quota from Redis in chunks (leases) and decides most events locally,
+// so Redis is touched once per LeaseSize events per collector instance.
+//
+// Accuracy bounds:
+//   - events are never accepted above the limits, since every lease is reserved in Redis atomically
+//   - up to LeaseSize-1 reserved but unused events per instance and key may be lost when a lease expires
+//   - after a rejection, events are rejected locally for up to LeaseTTL even if the window is reset in the meantime
+type Limiter struct {
+	mx          sync.Mutex
+	redisClient *redis.RedisClient
+
+	// LeaseSize is the maximum number of events reserved in Redis at once, values <= 1 disable leasing
+	LeaseSize int64
+
+	// LeaseTTL is the maximum time a lease or a rejection is trusted without asking Redis
+	LeaseTTL time.Duration
+
+	leases map[string]*lease
+}
+
+// lease represents quota reserved in Redis for a single key
+type lease struct {
+	// remaining number of events which could be accepted locally
+	remaining int64
+
+	// result of the last reservation in Redis, Exceeded is set for cached rejections
+	result redis.RateLimitResult
+
+	// time when the most restrictive limit is reset
+	resetAt time.Time
+
+	expiresAt time.Time
+}
+
+// status returns the state of the most restrictive limit for a client taking into account local consumption
+func (l *lease) status(now time.Time) redis.RateLimitResult {
+	status := l.result
+	status.Remaining += l.remaining
+	status.Reset = int64(l.resetAt.Sub(now).Round(time.Second) / time.Second)
+	if status.Reset < 0 {
+		status.Reset = 0
+	}
+	return status
+}
+
+// New creates limiter which reserves up to leaseSize events for leaseTTL
+func New(redisClient *redis.RedisClient, leaseSize int64, leaseTTL time.Duration) *Limiter {
+	return &Limiter{
+		redisClient: redisClient,
+		LeaseSize:   leaseSize,
+		LeaseTTL:    leaseTTL,
+		leases:      make(map[string]*lease),
+	}
+}
+
+// Allow counts the event by the rate limits hierarchy identified by key (e.g. project ID).
+// Result contains the index of the first exceeded limit or -1 if the event is within all limits,
+// and the status of the most restrictive limit.
+func (l *Limiter) Allow(key string, limits ...redis.RateLimit) (redis.RateLimitResult, error) {
+	if l.LeaseSize <= 1 || l.LeaseTTL <= 0 {
+		return l.redisClient.ReserveRateLimits(1, limits...)
+	}
+
+	now := time.Now()
+
+	l.mx.Lock()
+	current, ok := l.leases[key]
+	if ok && now.Before(current.expiresAt) {
+		if current.result.Exceeded != -1 {
+			l.mx.Unlock()
+			return current.status(now), nil
+		}
+		if current.remaining > 0 {
+			current.remaining--
+			status := current.status(now)
+			l.mx.Unlock()
+			return status, nil
+		}
+	}
+	l.mx.Unlock()
+
+	result, err := l.redisClient.ReserveRateLimits(l.LeaseSize, limits...)
+	if err != nil {
+		return result, err
+	}
+	log.Tracef("Rate limit lease for %s: %+v", key, result)
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	reserved := &lease{
+		result:    result,
+		resetAt:   now.Add(time.Duration(result.Reset) * time.Second),
+		expiresAt: now.Add(l.LeaseTTL),
+	}
+	if result.Exceeded == -1 {
+		reserved.remaining = result.Reserved - 1
+
+		// Another goroutine could have leased quota concurrently, keep its remainder as well
+		if current, ok := l.leases[key]; ok && current.result.Exceeded == -1 && now.Before(current.expiresAt) {
+			reserved.remaining += current.remaining
+		}
+	}
+	l.leases[key] = reserved
+
+	return reserved.status(now), nil
+}
+
+// Cleanup removes expired leases, should be run periodically
+func (l *Limiter) Cleanup() error {
+	now := time.Now()
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	for key, current := range l.leases {
+		if !now.Before(current.expiresAt) {
+			delete(l.leases, key)
+		}
+	}
+
+	return nil
+}
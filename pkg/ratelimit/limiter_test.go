@@ -0,0 +1,141 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/codex-team/hawk.collector/pkg/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupTestLimiter(t testing.TB, mr *miniredis.Miniredis, leaseSize int64) *Limiter {
+	client, err := redis.New(context.Background(), redis.Connection{URL: mr.Addr()}, "", "", "", "", "", "")
+	if err != nil {
+		t.Fatalf("Failed to create redis client: %v", err)
+	}
+	return New(client, leaseSize, time.Minute)
+}
+
+func TestLimiterSharedQuota(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to create mock redis: %v", err)
+	}
+	defer mr.Close()
+
+	const (
+		eventsLimit = 100
+		instances   = 3
+		calls       = 200
+		leaseSize   = 10
+	)
+	limit := redis.RateLimit{ID: "project1", Limit: eventsLimit, Period: 3600}
+
+	limiters := make([]*Limiter, instances)
+	for i := range limiters {
+		limiters[i] = setupTestLimiter(t, mr, leaseSize)
+	}
+
+	before := mr.CommandCount()
+	accepted := 0
+	for i := 0; i < calls; i++ {
+		for _, limiter := range limiters {
+			result, err := limiter.Allow(limit.ID, limit)
+			assert.NoError(t, err)
+			if result.Exceeded == -1 {
+				accepted++
+			}
+		}
+	}
+
+	// Leases never exceed the limit and all of them are used by active instances
+	assert.Equal(t, eventsLimit, accepted)
+
+	// Quota is leased in chunks and rejections are cached, so Redis is touched rarely:
+	// once per lease and once per instance to learn about the rejection.
+	// Every reservation runs EVAL, GET and SET of the counter key for a single limit.
+	const commandsPerReservation = 3
+	assert.LessOrEqual(t, mr.CommandCount()-before, (eventsLimit/leaseSize+instances)*commandsPerReservation)
+}
+
+func TestLimiterWithoutLeases(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to create mock redis: %v", err)
+	}
+	defer mr.Close()
+
+	limiter := setupTestLimiter(t, mr, 1)
+	limit := redis.RateLimit{ID: "project1", Limit: 3, Period: 60}
+
+	for i := 0; i < 3; i++ {
+		result, err := limiter.Allow(limit.ID, limit)
+		assert.NoError(t, err)
+		assert.Equal(t, -1, result.Exceeded)
+		assert.Equal(t, int64(2-i), result.Remaining)
+	}
+
+	result, err := limiter.Allow(limit.ID, limit)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Exceeded)
+}
+
+func TestLimiterLeaseStatus(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to create mock redis: %v", err)
+	}
+	defer mr.Close()
+
+	limiter := setupTestLimiter(t, mr, 10)
+	limit := redis.RateLimit{ID: "project1", Limit: 100, Period: 60}
+
+	// Events reserved by the lease are still reported as remaining until they are used locally
+	for i := 1; i <= 12; i++ {
+		result, err := limiter.Allow(limit.ID, limit)
+		assert.NoError(t, err)
+		assert.Equal(t, -1, result.Exceeded)
+		assert.Equal(t, int64(100), result.Limit)
+		assert.Equal(t, int64(100-i), result.Remaining)
+		assert.InDelta(t, 60, result.Reset, 1)
+	}
+}
+
+func benchmarkLimiter(b *testing.B, leaseSize int64) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		b.Fatalf("Failed to create mock redis: %v", err)
+	}
+	defer mr.Close()
+
+	limiter := setupTestLimiter(b, mr, leaseSize)
+	limits := []redis.RateLimit{
+		{ID: "project1", Limit: 1 << 40, Period: 3600},
+		{ID: "workspace:ws1", Limit: 1 << 40, Period: 3600, Algorithm: redis.TokenBucket},
+	}
+
+	b.ResetTimer()
+	before := mr.CommandCount()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := limiter.Allow("project1", limits...); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+	b.ReportMetric(float64(mr.CommandCount()-before)/float64(b.N), "redis-cmds/op")
+}
+
+func BenchmarkLimiterDirect(b *testing.B) {
+	benchmarkLimiter(b, 1)
+}
+
+func BenchmarkLimiterLease100(b *testing.B) {
+	benchmarkLimiter(b, 100)
+}
+
+func BenchmarkLimiterLease1000(b *testing.B) {
+	benchmarkLimiter(b, 1000)
+}
@@ -0,0 +1,98 @@
+package redis
+
+import (
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	log "github.com/sirupsen/logrus"
+)
+
+// Messages of the blocked projects channel, other messages trigger the full reload
+const (
+	blockMessagePrefix   = "block:"
+	unblockMessagePrefix = "unblock:"
+)
+
+// resubscribeDelay is the pause before receiving again after a pub/sub connection error
+const resubscribeDelay = time.Second
+
+// WatchBlockedIDs updates blocked IDs immediately on messages of the channel and keyspace notifications
+// of the blocked IDs set until the client context is done. Messages are "block:<id>" and "unblock:<id>",
+// other messages and keyspace notifications reload the whole set. The set is also reloaded on every
+// (re)subscription, since messages are lost while disconnected. Periodic LoadBlockedIDs stays as a safety net.
+func (r *RedisClient) WatchBlockedIDs(channel string) {
+	pubsub := r.rdb.PSubscribe(r.ctx, "__keyspace@*__:"+r.blockedIDsSetName)
+	defer pubsub.Close()
+
+	if channel != "" {
+		if err := pubsub.Subscribe(r.ctx, channel); err != nil {
+			log.Errorf("Failed to subscribe to blocked projects channel %q: %s", channel, err)
+		}
+	}
+
+	for {
+		message, err := pubsub.Receive(r.ctx)
+		if err != nil {
+			if r.ctx.Err() != nil {
+				return
+			}
+			log.Warnf("Blocked projects subscription error: %s", err)
+			time.Sleep(resubscribeDelay)
+			continue
+		}
+
+		switch message := message.(type) {
+		case *redis.Subscription:
+			if message.Kind == "subscribe" || message.Kind == "psubscribe" {
+				log.Debugf("Subscribed to %s, reloading blocked projects", message.Channel)
+				r.reloadBlockedIDs()
+			}
+		case *redis.Message:
+			r.applyBlockedIDsMessage(message)
+		}
+	}
+}
+
+// applyBlockedIDsMessage updates blocked IDs by the message of the channel or keyspace notification
+func (r *RedisClient) applyBlockedIDsMessage(message *redis.Message) {
+	if message.Pattern == "" {
+		if id := strings.TrimPrefix(message.Payload, blockMessagePrefix); id != message.Payload && id != "" {
+			r.setBlocked(id, true)
+			log.Infof("Project %s blocked by %s message", id, message.Channel)
+			return
+		}
+		if id := strings.TrimPrefix(message.Payload, unblockMessagePrefix); id != message.Payload && id != "" {
+			r.setBlocked(id, false)
+			log.Infof("Project %s unblocked by %s message", id, message.Channel)
+			return
+		}
+	}
+
+	log.Debugf("Blocked projects changed (%s: %s), reloading", message.Channel, message.Payload)
+	r.reloadBlockedIDs()
+}
+
+// setBlocked blocks or unblocks the ID, waiting for the running load to finish
+func (r *RedisClient) setBlocked(id string, blocked bool) {
+	r.blockedIDsMx.Lock()
+	defer r.blockedIDsMx.Unlock()
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if !blocked {
+		delete(r.blockedIDs, id)
+		return
+	}
+	if r.blockedIDs == nil {
+		r.blockedIDs = make(map[string]struct{})
+	}
+	r.blockedIDs[id] = struct{}{}
+}
+
+// reloadBlockedIDs loads blocked IDs once, errors are fixed by the next periodic reload
+func (r *RedisClient) reloadBlockedIDs() {
+	if err := r.load(); err != nil {
+		log.Errorf("Failed to reload blocked projects: %s", err)
+	}
+}
@@ -3,40 +3,78 @@ package redis
 import (
 	"context"
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
+	"github.com/codex-team/hawk.collector/pkg/clientip"
+	"github.com/codex-team/hawk.collector/pkg/iptrie"
 	"github.com/go-redis/redis/v8"
 	log "github.com/sirupsen/logrus"
 )
 
+// offencesKey is the counter of automatic bans of the IP
+const offencesKey = "ip-offences:%s"
+
 type RedisClient struct {
 	mx                   sync.RWMutex
-	rdb                  *redis.Client
+	rdb                  redis.UniversalClient
 	blockedIDsSetName    string
 	allIPsMapName        string
 	currentPeriodMapName string
 	blacklistSetName     string
+	allowlistSetName     string
+	expiryMapName        string
 	ctx                  context.Context
-	blockedIDs           []string
-	blacklistIPs         []string
+	blockedIDs           map[string]struct{}
+
+	// blockedIDsMx serializes loading of blocked IDs with updates by messages,
+	// so an update received while loading is not overwritten by the older snapshot
+	blockedIDsMx sync.Mutex
+
+	// ipCounts are requests per IP counted since the last flush
+	ipCountsMx sync.Mutex
+	ipCounts   map[string]ipCount
+
+	// blacklist and allowlist are IPs and CIDR prefixes, allowlist overrides blacklist
+	blacklist *iptrie.Trie
+	allowlist *iptrie.Trie
 }
 
-func New(ctx context.Context, url, pass, blockedIDsSet, blacklistSet, IPsMap, currentMap string) *RedisClient {
+// ipCount is the number of requests of an IP not added yet to the current period and all IPs maps,
+// they are tracked separately since one of the increments could fail
+type ipCount struct {
+	current int64
+	all     int64
+}
+
+func New(ctx context.Context, connection Connection, blockedIDsSet, blacklistSet, allowlistSet, expiryMap, IPsMap, currentMap string) (*RedisClient, error) {
+	rdb, err := newUniversalClient(connection)
+	if err != nil {
+		return nil, err
+	}
+
 	return &RedisClient{
-		rdb: redis.NewClient(&redis.Options{
-			Addr:     url,
-			Password: pass,
-			DB:       0,
-		}),
+		rdb:                  rdb,
 		ctx:                  ctx,
 		blockedIDsSetName:    blockedIDsSet,
 		allIPsMapName:        IPsMap,
 		currentPeriodMapName: currentMap,
 		blacklistSetName:     blacklistSet,
-	}
+		allowlistSetName:     allowlistSet,
+		expiryMapName:        expiryMap,
+		blockedIDs:           make(map[string]struct{}),
+		ipCounts:             make(map[string]ipCount),
+	}, nil
+}
+
+// IsCluster returns true if the client is connected to Redis Cluster
+func (r *RedisClient) IsCluster() bool {
+	_, ok := r.rdb.(*redis.ClusterClient)
+	return ok
 }
 
 // LoadBlockedIDs loads list of blocked IDs from Redis with retries.
@@ -67,8 +105,18 @@ func (r *RedisClient) LoadBlockedIDs() error {
 	}
 }
 
+// BlacklistUpdate is the result of the blacklist reload
+type BlacklistUpdate struct {
+	// IPs and Requests are the request counters of IPs for the last period
+	IPs      []string
+	Requests []string
+
+	// Expired are the blacklist entries removed since their expiry time passed
+	Expired []string
+}
+
 // LoadBlacklist loads list of blocked IP addresses from Redis.
-func (r *RedisClient) LoadBlacklist() ([]string, []string, error) {
+func (r *RedisClient) LoadBlacklist() (BlacklistUpdate, error) {
 	be := backoff.NewExponentialBackOff()
 	be.MaxElapsedTime = 3 * time.Minute
 	be.InitialInterval = 1 * time.Second
@@ -79,19 +127,22 @@ func (r *RedisClient) LoadBlacklist() ([]string, []string, error) {
 	for {
 		d := b.NextBackOff()
 		if d == backoff.Stop {
-			return nil, nil, fmt.Errorf("failed to connect")
+			return BlacklistUpdate{}, fmt.Errorf("failed to connect")
 		}
-		addrs, reqs, err := r.updateBlacklist()
+		update, err := r.updateBlacklist()
 		if err != nil {
 			continue
 		}
 		<-time.After(d)
-		return addrs, reqs, nil
+		return update, nil
 	}
 }
 
 // loads list with provided name from Redis.
 func (r *RedisClient) load() error {
+	r.blockedIDsMx.Lock()
+	defer r.blockedIDsMx.Unlock()
+
 	exists, existsErr := r.rdb.Exists(r.ctx, r.blockedIDsSetName).Result()
 	if existsErr != nil {
 		log.Errorf("Failed to check existence of blocked IDs set %q: %s", r.blockedIDsSetName, existsErr)
@@ -110,9 +161,14 @@ func (r *RedisClient) load() error {
 		return err
 	}
 
+	blockedIDs := make(map[string]struct{}, len(keys))
+	for _, id := range keys {
+		blockedIDs[id] = struct{}{}
+	}
+
 	r.mx.Lock()
 	prevCount := len(r.blockedIDs)
-	r.blockedIDs = keys
+	r.blockedIDs = blockedIDs
 	r.mx.Unlock()
 
 	if len(keys) != prevCount {
@@ -143,155 +199,306 @@ func (r *RedisClient) load() error {
 	return nil
 }
 
-// updateBlacklist loads IPs blacklist and resets current period map.
-func (r *RedisClient) updateBlacklist() ([]string, []string, error) {
+// updateBlacklist loads IPs blacklist and allowlist and resets current period map.
+func (r *RedisClient) updateBlacklist() (BlacklistUpdate, error) {
+	var update BlacklistUpdate
+
+	// the period includes requests counted since the last flush
+	if err := r.FlushIPCounts(); err != nil {
+		return update, err
+	}
+
 	ipAddrs, err := r.rdb.HKeys(r.ctx, r.currentPeriodMapName).Result()
 	if err != nil {
-		return nil, nil, err
+		return update, err
+	}
+
+	var expiry map[string]string
+	if r.expiryMapName != "" {
+		expiry, err = r.rdb.HGetAll(r.ctx, r.expiryMapName).Result()
+		if err != nil {
+			return update, err
+		}
 	}
 
-	ips, err := r.rdb.SMembers(r.ctx, r.blacklistSetName).Result()
+	blacklist, expired, err := r.loadIPList(r.blacklistSetName, expiry)
 	if err != nil {
-		return nil, nil, err
+		return update, err
+	}
+	allowlist, _, err := r.loadIPList(r.allowlistSetName, expiry)
+	if err != nil {
+		return update, err
 	}
+	update.Expired = expired
 
 	r.mx.Lock()
-	r.blacklistIPs = ips
+	r.blacklist = blacklist
+	r.allowlist = allowlist
 	r.mx.Unlock()
 
 	if len(ipAddrs) > 0 {
 		requests, err := r.rdb.HVals(r.ctx, r.currentPeriodMapName).Result()
 		if err != nil {
-			return nil, nil, err
+			return update, err
 		}
 
 		cmdResult := r.rdb.Del(r.ctx, r.currentPeriodMapName)
 		if cmdResult.Err() != nil {
-			return nil, nil, cmdResult.Err()
+			return update, cmdResult.Err()
+		}
+
+		update.IPs = ipAddrs
+		update.Requests = requests
+	}
+
+	return update, nil
+}
+
+// loadIPList loads set of IPs and CIDR prefixes into prefix tree.
+// expiry is the map of entries to their expiry Unix time in seconds, expired entries are removed from Redis and returned.
+func (r *RedisClient) loadIPList(setName string, expiry map[string]string) (*iptrie.Trie, []string, error) {
+	trie := iptrie.New()
+	if setName == "" {
+		return trie, nil, nil
+	}
+
+	entries, err := r.rdb.SMembers(r.ctx, setName).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var expired []string
+	now := time.Now()
+	for _, entry := range entries {
+		network, err := clientip.ParseNetwork(strings.TrimSpace(entry))
+		if err != nil {
+			log.Warnf("Skip invalid entry %q of IP set %q: %s", entry, setName, err)
+			continue
 		}
 
-		return ipAddrs, requests, nil
+		var expiresAt time.Time
+		if value, ok := expiry[entry]; ok {
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				log.Warnf("Invalid expiry %q of entry %q in %q", value, entry, r.expiryMapName)
+			} else {
+				expiresAt = time.Unix(seconds, 0)
+			}
+		}
+
+		if !expiresAt.IsZero() && !now.Before(expiresAt) {
+			removed, err := r.removeExpired(setName, entry, expiry[entry])
+			if err != nil {
+				log.Warnf("Failed to remove expired entry %q of IP set %q: %s", entry, setName, err)
+			}
+			if removed {
+				log.Debugf("Entry %q of IP set %q expired", entry, setName)
+				expired = append(expired, entry)
+			}
+			continue
+		}
+
+		trie.Insert(network, expiresAt)
 	}
 
-	return nil, nil, nil
+	return trie, expired, nil
+}
+
+// removeExpiryScript deletes the expiry of the entry only if it is unchanged since it was loaded,
+// so a ban renewed by another instance meanwhile is kept.
+const removeExpiryScript = `
+if redis.call('HGET', KEYS[1], ARGV[1]) == ARGV[2] then
+	return redis.call('HDEL', KEYS[1], ARGV[1])
+end
+return 0
+`
+
+// removeExpired removes the expired entry from the IP set if its expiry is still the loaded value,
+// returns false if the entry was renewed meanwhile.
+func (r *RedisClient) removeExpired(setName, entry, expiry string) (bool, error) {
+	// keys could belong to different Cluster slots, so the set entry is removed after the expiry is deleted
+	removed, err := r.rdb.Eval(r.ctx, removeExpiryScript, []string{r.expiryMapName}, entry, expiry).Int()
+	if err != nil || removed == 0 {
+		return false, err
+	}
+	return true, r.rdb.SRem(r.ctx, setName, entry).Err()
 }
 
 // IsBlocked checks if the provided ID is blocked.
 func (r *RedisClient) IsBlocked(val string) bool {
 	r.mx.RLock()
 	defer r.mx.RUnlock()
-	for _, id := range r.blockedIDs {
-		if id == val {
-			log.Debugf("IsBlocked: project %q matched in cache (size=%d)", val, len(r.blockedIDs))
-			return true
-		}
+	if _, ok := r.blockedIDs[val]; ok {
+		log.Debugf("IsBlocked: project %q matched in cache (size=%d)", val, len(r.blockedIDs))
+		return true
 	}
 	log.Tracef("IsBlocked: project %q not in cache (size=%d, key=%q)", val, len(r.blockedIDs), r.blockedIDsSetName)
 	return false
 }
 
 // IncrementIP increments the number of requests sent from provided IP.
-func (r *RedisClient) IncrementIP(ip string) error {
-	cmdResult := r.rdb.HIncrBy(r.ctx, r.currentPeriodMapName, ip, 1)
-	if cmdResult.Err() != nil {
-		return cmdResult.Err()
+// Counts are kept in memory until FlushIPCounts.
+func (r *RedisClient) IncrementIP(ip string) {
+	r.ipCountsMx.Lock()
+	if r.ipCounts == nil {
+		r.ipCounts = make(map[string]ipCount)
 	}
-	cmdResult = r.rdb.HIncrBy(r.ctx, r.allIPsMapName, ip, 1)
-	return cmdResult.Err()
+	count := r.ipCounts[ip]
+	count.current++
+	count.all++
+	r.ipCounts[ip] = count
+	r.ipCountsMx.Unlock()
 }
 
-// CheckBlacklist checks if the provided IP is in blacklist.
+// FlushIPCounts adds requests counted by IncrementIP to current period and all IPs maps in a single pipeline.
+// Counts which failed to be added are kept for the next flush.
+func (r *RedisClient) FlushIPCounts() error {
+	r.ipCountsMx.Lock()
+	counts := r.ipCounts
+	r.ipCounts = make(map[string]ipCount, len(counts))
+	r.ipCountsMx.Unlock()
+
+	if len(counts) == 0 {
+		return nil
+	}
+
+	current := make(map[string]*redis.IntCmd, len(counts))
+	all := make(map[string]*redis.IntCmd, len(counts))
+	_, err := r.rdb.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		for ip, count := range counts {
+			if count.current > 0 {
+				current[ip] = pipe.HIncrBy(r.ctx, r.currentPeriodMapName, ip, count.current)
+			}
+			if count.all > 0 {
+				all[ip] = pipe.HIncrBy(r.ctx, r.allIPsMapName, ip, count.all)
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		return nil
+	}
+
+	failed := 0
+	r.ipCountsMx.Lock()
+	for ip, count := range counts {
+		var retry ipCount
+		if cmd, ok := current[ip]; ok && cmd.Err() != nil {
+			retry.current = count.current
+		}
+		if cmd, ok := all[ip]; ok && cmd.Err() != nil {
+			retry.all = count.all
+		}
+		if retry == (ipCount{}) {
+			continue
+		}
+
+		failed++
+		pending := r.ipCounts[ip]
+		pending.current += retry.current
+		pending.all += retry.all
+		r.ipCounts[ip] = pending
+	}
+	r.ipCountsMx.Unlock()
+
+	return fmt.Errorf("failed to flush counts of %d IPs: %w", failed, err)
+}
+
+// CheckBlacklist checks if the provided IP belongs to the blacklist and not to the allowlist.
 func (r *RedisClient) CheckBlacklist(ip string) bool {
 	r.mx.RLock()
 	defer r.mx.RUnlock()
-	if len(r.blacklistIPs) == 0 {
+	if r.blacklist == nil || r.blacklist.Len() == 0 {
 		return false
 	}
-	for _, blockedIP := range r.blacklistIPs {
-		if ip == blockedIP {
-			return true
-		}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
 	}
-	return false
+
+	now := time.Now()
+	if r.allowlist != nil && r.allowlist.Contains(parsed, now) {
+		return false
+	}
+	return r.blacklist.Contains(parsed, now)
 }
 
-// CheckAvailability checks if redis is available
-func (r *RedisClient) CheckAvailability() bool {
-	pong, err := r.rdb.Ping(r.ctx).Result()
-	if err != nil {
+// IsAllowlisted checks if the provided IP belongs to the allowlist.
+func (r *RedisClient) IsAllowlisted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
 		return false
 	}
-	return pong == "PONG"
+
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	return r.allowlist != nil && r.allowlist.Contains(parsed, time.Now())
 }
 
-// UpdateRateLimit checks and updates the rate limit for a project using a Lua script
-func (r *RedisClient) UpdateRateLimit(projectID string, eventsLimit int64, eventsPeriod int64) (bool, error) {
-	// If eventsLimit is 0, we don't need to update the rate limit
-	if eventsLimit == 0 {
-		return true, nil
-	}
-
-	// Lua script for atomic rate limit check and update
-	script := `
-		local key = KEYS[1]
-		local field = ARGV[1]
-		local now = tonumber(ARGV[2])
-		local limit = tonumber(ARGV[3])
-		local period = tonumber(ARGV[4])
-
-		local current = redis.call('HGET', key, field)
-		if not current then
-			-- No existing record, create new window
-			redis.call('HSET', key, field, now .. ':1')
-			return 1
-		end
-
-		local timestamp, count = string.match(current, '(%d+):(%d+)')
-		timestamp = tonumber(timestamp)
-		count = tonumber(count)
-
-		-- Check if we're in a new time window
-		if now - timestamp >= period then
-			-- Reset for new window
-			redis.call('HSET', key, field, now .. ':1')
-			return 1
-		end
-
-		-- Check if incrementing would exceed limit
-		if count + 1 > limit then
-			return 0
-		end
-
-		-- Increment counter
-		redis.call('HSET', key, field, timestamp .. ':' .. (count + 1))
-		return 1
-	`
-
-	// Run the script
-	result, err := r.rdb.Eval(
-		r.ctx,
-		script,
-		[]string{"rate_limits"}, // KEYS
-		projectID,               // field (ARGV[1])
-		time.Now().Unix(),       // now (ARGV[2])
-		eventsLimit,             // limit (ARGV[3])
-		eventsPeriod,            // period (ARGV[4])
-	).Result()
+// BanIP adds the IP to the blacklist until the provided time.
+// The IP is blocked by this instance immediately and by others after their next blacklist update.
+func (r *RedisClient) BanIP(ip string, until time.Time) error {
+	if r.blacklistSetName == "" || r.expiryMapName == "" {
+		return fmt.Errorf("blacklist set and expiry map are required for temporary bans")
+	}
 
+	network, err := clientip.ParseNetwork(ip)
 	if err != nil {
-		return false, fmt.Errorf("failed to execute rate limit script: %w", err)
+		return err
 	}
 
-	// Script returns 1 if rate limit is not exceeded, 0 if it is
-	return result.(int64) == 1, nil
+	// keys could belong to different Cluster slots, so the commands are not transactional
+	_, err = r.rdb.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(r.ctx, r.blacklistSetName, ip)
+		pipe.HSet(r.ctx, r.expiryMapName, ip, until.Unix())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.mx.Lock()
+	if r.blacklist == nil {
+		r.blacklist = iptrie.New()
+	}
+	r.blacklist.Insert(network, until)
+	r.mx.Unlock()
+
+	return nil
+}
+
+// IncrementOffences increments the number of bans of the IP remembered for the provided duration.
+func (r *RedisClient) IncrementOffences(ip string, memory time.Duration) (int64, error) {
+	key := fmt.Sprintf(offencesKey, ip)
+	var incr *redis.IntCmd
+	_, err := r.rdb.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(r.ctx, key)
+		pipe.Expire(r.ctx, key, memory)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// CheckAvailability checks if redis is available
+func (r *RedisClient) CheckAvailability() bool {
+	pong, err := r.rdb.Ping(r.ctx).Result()
+	if err != nil {
+		return false
+	}
+	return pong == "PONG"
 }
 
 // TSCreateIfNotExists creates a RedisTimeSeries key if it doesn't exist.
-// It sets optional retention policy and attaches labels.
+// It sets optional retention policy, attaches labels and creates compaction rules with their destinations.
 func (r *RedisClient) TSCreateIfNotExists(
 	key string,
 	labels map[string]string,
 	retention time.Duration,
+	rules ...TSRule,
 ) error {
 	exists, err := r.rdb.Exists(r.ctx, key).Result()
 	if err != nil {
@@ -301,19 +508,7 @@ func (r *RedisClient) TSCreateIfNotExists(
 		return nil // already exists
 	}
 
-	labelArgs := []interface{}{"LABELS"}
-	for k, v := range labels {
-		labelArgs = append(labelArgs, k, v)
-	}
-
-	args := []interface{}{key}
-	if retention > 0 {
-		args = append(args, "RETENTION", int64(retention/time.Millisecond))
-	}
-	args = append(args, labelArgs...)
-
-	res := r.rdb.Do(r.ctx, append([]interface{}{"TS.CREATE"}, args...)...)
-	return res.Err()
+	return r.TSCreateAll([]TSKey{{Key: key, Labels: labels, Retention: retention, Rules: rules}})
 }
 
 // TSIncrBy increments a RedisTimeSeries key with labels and timestamp.
@@ -413,3 +608,43 @@ func (r *RedisClient) SafeTSAdd(
 	}
 	return err
 }
+
+// TSSample is a single sample of a RedisTimeSeries key
+type TSSample struct {
+	// Timestamp in milliseconds
+	Timestamp int64
+	Value     float64
+}
+
+// TSRange returns samples of a time series between from and to timestamps in milliseconds.
+// Returns empty slice if the key doesn't exist.
+func (r *RedisClient) TSRange(key string, from, to int64) ([]TSSample, error) {
+	res, err := r.rdb.Do(r.ctx, "TS.RANGE", key, from, to).Result()
+	if err != nil {
+		if strings.Contains(err.Error(), "TSDB: the key does not exist") || strings.Contains(err.Error(), "TSDB: key does not exist") {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	rows, ok := res.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected TS.RANGE result: %v", res)
+	}
+
+	samples := make([]TSSample, 0, len(rows))
+	for _, row := range rows {
+		pair, ok := row.([]interface{})
+		if !ok || len(pair) != 2 {
+			return nil, fmt.Errorf("unexpected TS.RANGE sample: %v", row)
+		}
+		timestamp, _ := pair[0].(int64)
+		value, err := strconv.ParseFloat(fmt.Sprint(pair[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected TS.RANGE value: %w", err)
+		}
+		samples = append(samples, TSSample{Timestamp: timestamp, Value: value})
+	}
+
+	return samples, nil
+}
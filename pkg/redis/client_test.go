@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
 	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/assert"
 )
@@ -58,8 +59,8 @@ func TestUpdateRateLimit(t *testing.T) {
 			eventsLimit:  10,
 			eventsPeriod: 60,
 			setup: func() {
-				client.rdb.HSet(client.ctx, "rate_limits", "project2",
-					fmt.Sprintf("%d:%d", time.Now().Unix()-30, 5))
+				client.rdb.Set(client.ctx, rateLimitKey("project2", "project2"),
+					fmt.Sprintf("%d:%d", time.Now().Unix()-30, 5), 0)
 			},
 			calls:       1,
 			wantAllowed: true,
@@ -71,8 +72,8 @@ func TestUpdateRateLimit(t *testing.T) {
 			eventsLimit:  5,
 			eventsPeriod: 60,
 			setup: func() {
-				client.rdb.HSet(client.ctx, "rate_limits", "project3",
-					fmt.Sprintf("%d:%d", time.Now().Unix()-30, 5))
+				client.rdb.Set(client.ctx, rateLimitKey("project3", "project3"),
+					fmt.Sprintf("%d:%d", time.Now().Unix()-30, 5), 0)
 			},
 			calls:       1,
 			wantAllowed: false,
@@ -84,8 +85,8 @@ func TestUpdateRateLimit(t *testing.T) {
 			eventsLimit:  5,
 			eventsPeriod: 60,
 			setup: func() {
-				client.rdb.HSet(client.ctx, "rate_limits", "project4",
-					fmt.Sprintf("%d:%d", time.Now().Unix()-61, 5))
+				client.rdb.Set(client.ctx, rateLimitKey("project4", "project4"),
+					fmt.Sprintf("%d:%d", time.Now().Unix()-61, 5), 0)
 			},
 			calls:       1,
 			wantAllowed: true,
@@ -123,7 +124,7 @@ func TestUpdateRateLimit(t *testing.T) {
 
 			// Make the specified number of calls
 			for i := 0; i < tt.calls; i++ {
-				lastAllowed, lastErr = client.UpdateRateLimit(tt.projectID, tt.eventsLimit, tt.eventsPeriod)
+				lastAllowed, lastErr = client.UpdateRateLimit(tt.projectID, tt.eventsLimit, tt.eventsPeriod, FixedWindow)
 			}
 
 			if tt.wantErr {
@@ -136,6 +137,272 @@ func TestUpdateRateLimit(t *testing.T) {
 	}
 }
 
+func TestUpdateRateLimitAlgorithms(t *testing.T) {
+	client, mr := setupTestRedis(t)
+	defer mr.Close()
+
+	nowMs := time.Now().UnixNano() / int64(time.Millisecond)
+	const periodMs = 3600 * 1000
+	window := nowMs - nowMs%periodMs
+
+	tests := []struct {
+		name         string
+		projectID    string
+		algorithm    string
+		eventsLimit  int64
+		eventsPeriod int64
+		setup        func()
+		calls        int
+		wantAllowed  bool
+		wantErr      bool
+	}{
+		{
+			name:         "sliding window should allow when no previous events",
+			projectID:    "project1",
+			algorithm:    SlidingWindow,
+			eventsLimit:  10,
+			eventsPeriod: 3600,
+			calls:        1,
+			wantAllowed:  true,
+		},
+		{
+			name:         "sliding window should deny when previous window is still saturated",
+			projectID:    "project2",
+			algorithm:    SlidingWindow,
+			eventsLimit:  10,
+			eventsPeriod: 3600,
+			setup: func() {
+				client.rdb.Set(client.ctx, rateLimitKey("project2", "project2:sliding-window"),
+					fmt.Sprintf("%d:%d:%d", window-periodMs, 0, 1000000), 0)
+			},
+			calls:       1,
+			wantAllowed: false,
+		},
+		{
+			name:         "sliding window should forget windows older than the previous one",
+			projectID:    "project3",
+			algorithm:    SlidingWindow,
+			eventsLimit:  10,
+			eventsPeriod: 3600,
+			setup: func() {
+				client.rdb.Set(client.ctx, rateLimitKey("project3", "project3:sliding-window"),
+					fmt.Sprintf("%d:%d:%d", window-2*periodMs, 1000000, 1000000), 0)
+			},
+			calls:       1,
+			wantAllowed: true,
+		},
+		{
+			name:         "sliding window should handle multiple calls up to limit",
+			projectID:    "project4",
+			algorithm:    SlidingWindow,
+			eventsLimit:  3,
+			eventsPeriod: 3600,
+			calls:        4,
+			wantAllowed:  false,
+		},
+		{
+			name:         "token bucket should allow when no previous events",
+			projectID:    "project5",
+			algorithm:    TokenBucket,
+			eventsLimit:  10,
+			eventsPeriod: 60,
+			calls:        1,
+			wantAllowed:  true,
+		},
+		{
+			name:         "token bucket should deny when bucket is empty",
+			projectID:    "project6",
+			algorithm:    TokenBucket,
+			eventsLimit:  10,
+			eventsPeriod: 60,
+			setup: func() {
+				client.rdb.Set(client.ctx, rateLimitKey("project6", "project6:token-bucket"),
+					fmt.Sprintf("0.000000:%d", nowMs+1000), 0)
+			},
+			calls:       1,
+			wantAllowed: false,
+		},
+		{
+			name:         "token bucket should refill tokens over time",
+			projectID:    "project7",
+			algorithm:    TokenBucket,
+			eventsLimit:  10,
+			eventsPeriod: 60,
+			setup: func() {
+				client.rdb.Set(client.ctx, rateLimitKey("project7", "project7:token-bucket"),
+					fmt.Sprintf("0.000000:%d", nowMs-30000), 0)
+			},
+			calls:       5,
+			wantAllowed: true,
+		},
+		{
+			name:         "token bucket should handle multiple calls up to limit",
+			projectID:    "project8",
+			algorithm:    TokenBucket,
+			eventsLimit:  3,
+			eventsPeriod: 3600,
+			calls:        4,
+			wantAllowed:  false,
+		},
+		{
+			name:         "should fall back to fixed window when algorithm is empty",
+			projectID:    "project9",
+			algorithm:    "",
+			eventsLimit:  3,
+			eventsPeriod: 60,
+			calls:        4,
+			wantAllowed:  false,
+		},
+		{
+			name:         "should fall back to fixed window on unknown algorithm",
+			projectID:    "project10",
+			algorithm:    "leaky-bucket",
+			eventsLimit:  3,
+			eventsPeriod: 60,
+			calls:        3,
+			wantAllowed:  true,
+		},
+		{
+			name:         "should deny by fixed window on unknown algorithm",
+			projectID:    "project11",
+			algorithm:    "leaky-bucket",
+			eventsLimit:  3,
+			eventsPeriod: 60,
+			calls:        4,
+			wantAllowed:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setup != nil {
+				tt.setup()
+			}
+
+			var lastAllowed bool
+			var lastErr error
+
+			for i := 0; i < tt.calls; i++ {
+				lastAllowed, lastErr = client.UpdateRateLimit(tt.projectID, tt.eventsLimit, tt.eventsPeriod, tt.algorithm)
+			}
+
+			if tt.wantErr {
+				assert.Error(t, lastErr)
+			} else {
+				assert.NoError(t, lastErr)
+			}
+			assert.Equal(t, tt.wantAllowed, lastAllowed)
+		})
+	}
+}
+
+func TestUpdateRateLimitsWorkspaceQuota(t *testing.T) {
+	client, mr := setupTestRedis(t)
+	defer mr.Close()
+
+	// Projects counters are kept in the workspace group
+	workspace := RateLimit{ID: "workspace:ws1", Limit: 5, Period: 60, Group: "workspace:ws1"}
+	projects := []RateLimit{
+		{ID: "project1", Limit: 4, Period: 60},
+		{ID: "project2", Limit: 4, Period: 60, Algorithm: TokenBucket},
+	}
+
+	// Projects share the workspace quota
+	for i := 0; i < 5; i++ {
+		exceeded, err := client.UpdateRateLimits(projects[i%2], workspace)
+		assert.NoError(t, err)
+		assert.Equal(t, -1, exceeded)
+	}
+
+	exceeded, err := client.UpdateRateLimits(projects[1], workspace)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, exceeded, "workspace quota should be exceeded")
+
+	// Rejected event must not be counted by the project level
+	val, err := client.rdb.Get(client.ctx, rateLimitKey("workspace:ws1", "project1")).Result()
+	assert.NoError(t, err)
+	count := 0
+	_, err = fmt.Sscanf(val, "%d:%d", &count, &count)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	// Project limit is checked without the workspace quota as well
+	project := projects[0]
+	project.Group = "workspace:ws1"
+	exceeded, err = client.UpdateRateLimits(project)
+	assert.NoError(t, err)
+	assert.Equal(t, -1, exceeded)
+
+	exceeded, err = client.UpdateRateLimits(project)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, exceeded, "project limit should be exceeded")
+}
+
+func TestReserveRateLimits(t *testing.T) {
+	client, mr := setupTestRedis(t)
+	defer mr.Close()
+
+	limits := []RateLimit{
+		{ID: "project1", Limit: 25, Period: 60},
+		{ID: "workspace:ws1", Limit: 100, Period: 60, Algorithm: SlidingWindow},
+	}
+
+	for _, want := range []int64{10, 10, 5} {
+		result, err := client.ReserveRateLimits(10, limits...)
+		assert.NoError(t, err)
+		assert.Equal(t, -1, result.Exceeded)
+		assert.Equal(t, want, result.Reserved)
+	}
+
+	result, err := client.ReserveRateLimits(10, limits...)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Exceeded)
+	assert.Equal(t, int64(0), result.Reserved)
+
+	// Workspace counter has been updated by the reserved events only
+	val, err := client.rdb.Get(client.ctx, rateLimitKey("project1", "workspace:ws1:sliding-window")).Result()
+	assert.NoError(t, err)
+	var window, previous, current int64
+	_, err = fmt.Sscanf(val, "%d:%d:%d", &window, &previous, &current)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(25), current)
+}
+
+func TestReserveRateLimitsStatus(t *testing.T) {
+	client, mr := setupTestRedis(t)
+	defer mr.Close()
+
+	limits := []RateLimit{
+		{ID: "project1", Limit: 10, Period: 60},
+		{ID: "workspace:ws1", Limit: 5, Period: 120, Algorithm: TokenBucket},
+	}
+
+	// Workspace limit is the most restrictive one
+	result, err := client.ReserveRateLimits(1, limits...)
+	assert.NoError(t, err)
+	assert.Equal(t, RateLimitResult{Reserved: 1, Exceeded: -1, Limit: 5, Remaining: 4, Reset: 24}, result)
+
+	result, err = client.ReserveRateLimits(4, limits...)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), result.Remaining)
+	assert.InDelta(t, 120, result.Reset, 1)
+
+	// Rejected event reports the exceeded limit
+	result, err = client.ReserveRateLimits(1, limits...)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Exceeded)
+	assert.Equal(t, int64(5), result.Limit)
+	assert.Equal(t, int64(0), result.Remaining)
+	assert.InDelta(t, 24, result.Reset, 1, "exceeded token bucket reports the time until the next token")
+
+	// Project limit reports the end of the fixed window
+	result, err = client.ReserveRateLimits(1, limits[0])
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), result.Limit)
+	assert.Equal(t, int64(4), result.Remaining)
+	assert.InDelta(t, 60, result.Reset, 1)
+}
+
 // Regression: load() must return all entries from large blocked ID sets.
 func TestLoadBlockedIDsLargeSet(t *testing.T) {
 	client, mr := setupTestRedis(t)
@@ -180,7 +447,7 @@ func TestUpdateRateLimitConcurrent(t *testing.T) {
 	for i := 0; i < goroutines; i++ {
 		go func() {
 			for j := 0; j < callsPerRoutine; j++ {
-				allowed, err := client.UpdateRateLimit(projectID, eventsLimit, eventsPeriod)
+				allowed, err := client.UpdateRateLimit(projectID, eventsLimit, eventsPeriod, FixedWindow)
 				assert.NoError(t, err)
 				if !allowed {
 					rejectedCount++
@@ -196,7 +463,7 @@ func TestUpdateRateLimitConcurrent(t *testing.T) {
 	}
 
 	// Verify the total number of successful updates doesn't exceed the limit
-	val, err := client.rdb.HGet(client.ctx, "rate_limits", projectID).Result()
+	val, err := client.rdb.Get(client.ctx, rateLimitKey(projectID, projectID)).Result()
 	assert.NoError(t, err)
 	assert.NotEmpty(t, val)
 
@@ -209,3 +476,181 @@ func TestUpdateRateLimitConcurrent(t *testing.T) {
 	t.Logf("count: %d", count)
 	t.Logf("rejectedCount: %d", rejectedCount)
 }
+
+func TestCheckBlacklist(t *testing.T) {
+	client, mr := setupTestRedis(t)
+	defer mr.Close()
+	client.blacklistSetName = "blacklist"
+	client.allowlistSetName = "allowlist"
+	client.expiryMapName = "expiry"
+	client.currentPeriodMapName = "current"
+
+	mr.SAdd("blacklist", "203.0.113.0/24", "2001:db8:1::/64", "198.51.100.7", "192.0.2.1", "garbage")
+	mr.SAdd("allowlist", "203.0.113.10")
+	mr.HSet("expiry", "192.0.2.1", fmt.Sprint(time.Now().Add(-time.Minute).Unix()))
+	mr.HSet("expiry", "198.51.100.7", fmt.Sprint(time.Now().Add(time.Hour).Unix()))
+
+	update, err := client.updateBlacklist()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"192.0.2.1"}, update.Expired)
+
+	assert.True(t, client.CheckBlacklist("203.0.113.1"))
+	assert.False(t, client.CheckBlacklist("203.0.113.10"))
+	assert.True(t, client.CheckBlacklist("2001:db8:1::42"))
+	assert.False(t, client.CheckBlacklist("2001:db8:2::42"))
+	assert.True(t, client.CheckBlacklist("198.51.100.7"))
+	assert.False(t, client.CheckBlacklist("192.0.2.1"))
+	assert.False(t, client.CheckBlacklist("not an ip"))
+
+	// expired entries are removed from Redis
+	isMember, _ := mr.SIsMember("blacklist", "192.0.2.1")
+	assert.False(t, isMember)
+	assert.Empty(t, mr.HGet("expiry", "192.0.2.1"))
+}
+
+func TestLoadIPListKeepsRenewedBan(t *testing.T) {
+	client, mr := setupTestRedis(t)
+	defer mr.Close()
+	client.expiryMapName = "expiry"
+
+	mr.SAdd("blacklist", "192.0.2.1")
+	expired := fmt.Sprint(time.Now().Add(-time.Minute).Unix())
+	mr.HSet("expiry", "192.0.2.1", fmt.Sprint(time.Now().Add(time.Hour).Unix()))
+
+	// the ban is renewed by another instance after the expiry map was loaded
+	_, removed, err := client.loadIPList("blacklist", map[string]string{"192.0.2.1": expired})
+	assert.NoError(t, err)
+	assert.Empty(t, removed)
+
+	isMember, _ := mr.SIsMember("blacklist", "192.0.2.1")
+	assert.True(t, isMember)
+	assert.NotEmpty(t, mr.HGet("expiry", "192.0.2.1"))
+}
+
+func TestFlushIPCounts(t *testing.T) {
+	client, mr := setupTestRedis(t)
+	defer mr.Close()
+	client.currentPeriodMapName = "current"
+	client.allIPsMapName = "all"
+
+	client.IncrementIP("203.0.113.7")
+	client.IncrementIP("203.0.113.7")
+	client.IncrementIP("198.51.100.1")
+	assert.False(t, mr.Exists("current"))
+
+	assert.NoError(t, client.FlushIPCounts())
+	assert.Equal(t, "2", mr.HGet("current", "203.0.113.7"))
+	assert.Equal(t, "1", mr.HGet("all", "198.51.100.1"))
+
+	// counts are kept if Redis is unavailable
+	client.IncrementIP("203.0.113.7")
+	mr.SetError("unavailable")
+	assert.Error(t, client.FlushIPCounts())
+	mr.SetError("")
+	assert.NoError(t, client.FlushIPCounts())
+	assert.Equal(t, "3", mr.HGet("all", "203.0.113.7"))
+
+	// only failed increments are retried, so succeeded ones are not counted twice
+	client.IncrementIP("198.51.100.1")
+	mr.Del("all")
+	assert.NoError(t, mr.Set("all", "not a hash"))
+	assert.Error(t, client.FlushIPCounts())
+	assert.Equal(t, "2", mr.HGet("current", "198.51.100.1"))
+	mr.Del("all")
+	assert.NoError(t, client.FlushIPCounts())
+	assert.Equal(t, "2", mr.HGet("current", "198.51.100.1"))
+	assert.Equal(t, "1", mr.HGet("all", "198.51.100.1"))
+
+	// blacklist update includes counts since the last flush
+	client.IncrementIP("203.0.113.7")
+	update, err := client.updateBlacklist()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"198.51.100.1", "203.0.113.7"}, update.IPs)
+	assert.Equal(t, []string{"2", "4"}, update.Requests)
+	assert.False(t, mr.Exists("current"))
+}
+
+func BenchmarkIncrementIP(b *testing.B) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		b.Fatalf("Failed to create mock redis: %v", err)
+	}
+	defer mr.Close()
+
+	client, err := New(context.Background(), Connection{URL: mr.Addr()}, "", "", "", "", "all", "current")
+	if err != nil {
+		b.Fatalf("Failed to create redis client: %v", err)
+	}
+	ips := []string{"203.0.113.1", "203.0.113.2", "203.0.113.3", "2001:db8::1"}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			client.IncrementIP(ips[i%len(ips)])
+			i++
+		}
+	})
+	b.StopTimer()
+
+	if err := client.FlushIPCounts(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func TestWatchBlockedIDs(t *testing.T) {
+	client, mr := setupTestRedis(t)
+	defer mr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client.ctx = ctx
+	client.blockedIDsSetName = "DisabledProjectsSet"
+	client.blockedIDs = make(map[string]struct{})
+	mr.SAdd(client.blockedIDsSetName, "project1")
+
+	go client.WatchBlockedIDs("BlockedProjectsChannel")
+
+	// the set is loaded on subscription
+	assert.Eventually(t, func() bool { return client.IsBlocked("project1") }, time.Second, 10*time.Millisecond)
+
+	mr.Publish("BlockedProjectsChannel", "block:project2")
+	assert.Eventually(t, func() bool { return client.IsBlocked("project2") }, time.Second, 10*time.Millisecond)
+
+	mr.Publish("BlockedProjectsChannel", "unblock:project1")
+	assert.Eventually(t, func() bool { return !client.IsBlocked("project1") }, time.Second, 10*time.Millisecond)
+
+	// other messages reload the whole set
+	mr.SRem(client.blockedIDsSetName, "project2")
+	mr.SAdd(client.blockedIDsSetName, "project3")
+	mr.Publish("BlockedProjectsChannel", "reload")
+	assert.Eventually(t, func() bool {
+		return client.IsBlocked("project3") && !client.IsBlocked("project2")
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestBlockedIDsMessageWhileLoading(t *testing.T) {
+	client, mr := setupTestRedis(t)
+	defer mr.Close()
+	client.blockedIDsSetName = "DisabledProjectsSet"
+	mr.SAdd(client.blockedIDsSetName, "project1")
+
+	// the message is received after SMEMBERS returned the snapshot without project2
+	applied := make(chan struct{})
+	mr.Server().SetPreHook(func(c *server.Peer, cmd string, args ...string) bool {
+		if cmd == "SMEMBERS" {
+			go func() {
+				client.applyBlockedIDsMessage(&redis.Message{Channel: "BlockedProjectsChannel", Payload: "block:project2"})
+				close(applied)
+			}()
+			time.Sleep(50 * time.Millisecond)
+		}
+		return false
+	})
+
+	assert.NoError(t, client.load())
+	<-applied
+
+	assert.True(t, client.IsBlocked("project1"))
+	assert.True(t, client.IsBlocked("project2"))
+}
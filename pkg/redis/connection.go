@@ -0,0 +1,235 @@
+package redis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Connection describes how to connect to Redis.
+// Values set in the URL take precedence over the fields.
+type Connection struct {
+	// URL is "host:port" or one of the URL forms:
+	//
+	//	redis://[[username]:password@]host:port[/db]
+	//	redis+sentinel://[[username]:password@]host:port[,host:port...]/master[/db][?sentinel_password=...]
+	//	redis+cluster://[[username]:password@]host:port[,host:port...]
+	//
+	// rediss, rediss+sentinel and rediss+cluster schemes enable TLS
+	URL string
+
+	// Username is the ACL user, empty value means the default user
+	Username string
+	Password string
+	DB       int
+
+	// TLSCAFile is the PEM file with CA certificates to verify the server, empty value means system roots
+	TLSCAFile string
+
+	// PoolSize is the maximum number of connections per node, 0 means go-redis default
+	PoolSize int
+
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+// Redacted returns the URL without the password to be logged
+func (c Connection) Redacted() string {
+	scheme, rest := cut(c.URL, "://")
+	if rest == "" {
+		return c.URL
+	}
+
+	authority, path := cut(rest, "/")
+	at := strings.LastIndex(authority, "@")
+	if at == -1 {
+		return c.URL
+	}
+
+	username, _ := cut(authority[:at], ":")
+	redacted := scheme + "://" + username + ":***" + authority[at:]
+	if path != "" || strings.Contains(rest, "/") {
+		redacted += "/" + path
+	}
+	return redacted
+}
+
+// Connection modes
+const (
+	single   = "single"
+	sentinel = "sentinel"
+	cluster  = "cluster"
+)
+
+// options returns options of the universal client and the connection mode
+func (c Connection) options() (*redis.UniversalOptions, string, error) {
+	options := &redis.UniversalOptions{
+		Username:     c.Username,
+		Password:     c.Password,
+		DB:           c.DB,
+		PoolSize:     c.PoolSize,
+		DialTimeout:  c.DialTimeout,
+		ReadTimeout:  c.ReadTimeout,
+		WriteTimeout: c.WriteTimeout,
+	}
+
+	// plain address is kept for backward compatibility
+	if !strings.Contains(c.URL, "://") {
+		options.Addrs = []string{c.URL}
+		return options, single, nil
+	}
+
+	scheme, rest := cut(c.URL, "://")
+	var mode string
+	var secure bool
+	switch scheme {
+	case "redis":
+		mode = single
+	case "rediss":
+		mode, secure = single, true
+	case "redis+sentinel":
+		mode = sentinel
+	case "rediss+sentinel":
+		mode, secure = sentinel, true
+	case "redis+cluster":
+		mode = cluster
+	case "rediss+cluster":
+		mode, secure = cluster, true
+	default:
+		return nil, "", fmt.Errorf("unsupported Redis URL scheme %q", scheme)
+	}
+
+	rest, rawQuery := cut(rest, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid Redis URL query: %w", err)
+	}
+
+	authority, path := cut(rest, "/")
+	if at := strings.LastIndex(authority, "@"); at != -1 {
+		username, password := cut(authority[:at], ":")
+		if username, err = url.PathUnescape(username); err != nil {
+			return nil, "", fmt.Errorf("invalid Redis URL username: %w", err)
+		}
+		if password, err = url.PathUnescape(password); err != nil {
+			return nil, "", fmt.Errorf("invalid Redis URL password: %w", err)
+		}
+		if username != "" {
+			options.Username = username
+		}
+		if password != "" {
+			options.Password = password
+		}
+		authority = authority[at+1:]
+	}
+
+	for _, addr := range strings.Split(authority, ",") {
+		if addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, defaultPort(mode))
+		}
+		options.Addrs = append(options.Addrs, addr)
+	}
+	if len(options.Addrs) == 0 {
+		return nil, "", fmt.Errorf("Redis URL has no addresses")
+	}
+	if mode == single && len(options.Addrs) > 1 {
+		return nil, "", fmt.Errorf("Redis URL has several addresses, use redis+cluster or redis+sentinel scheme")
+	}
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if mode == sentinel {
+		if segments[0] == "" {
+			return nil, "", fmt.Errorf("Redis sentinel URL has no master name")
+		}
+		options.MasterName = segments[0]
+		segments = segments[1:]
+		options.SentinelPassword = query.Get("sentinel_password")
+	}
+	if len(segments) > 0 && segments[0] != "" {
+		if mode == cluster {
+			return nil, "", fmt.Errorf("Redis cluster doesn't support databases")
+		}
+		options.DB, err = strconv.Atoi(segments[0])
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid Redis database %q", segments[0])
+		}
+	}
+
+	if secure {
+		// sentinels, masters and cluster nodes have their own host names,
+		// the name is verified by the host of each connection if ServerName is empty
+		serverName := ""
+		if mode == single {
+			serverName, _, _ = net.SplitHostPort(options.Addrs[0])
+		}
+		options.TLSConfig, err = c.tlsConfig(serverName)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return options, mode, nil
+}
+
+// tlsConfig returns TLS configuration verifying the server name by the CA file or system roots
+func (c Connection) tlsConfig(serverName string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: serverName}
+
+	if c.TLSCAFile == "" {
+		return config, nil
+	}
+
+	pem, err := ioutil.ReadFile(c.TLSCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Redis CA file: %w", err)
+	}
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in Redis CA file %s", c.TLSCAFile)
+	}
+	return config, nil
+}
+
+// newUniversalClient creates client of the connection mode
+func newUniversalClient(connection Connection) (redis.UniversalClient, error) {
+	options, mode, err := connection.options()
+	if err != nil {
+		return nil, err
+	}
+
+	switch mode {
+	case sentinel:
+		return redis.NewFailoverClient(options.Failover()), nil
+	case cluster:
+		return redis.NewClusterClient(options.Cluster()), nil
+	default:
+		return redis.NewClient(options.Simple()), nil
+	}
+}
+
+func defaultPort(mode string) string {
+	if mode == sentinel {
+		return "26379"
+	}
+	return "6379"
+}
+
+// cut splits s around the first separator, the second part is empty if there is no separator
+func cut(s, separator string) (string, string) {
+	if i := strings.Index(s, separator); i != -1 {
+		return s[:i], s[i+len(separator):]
+	}
+	return s, ""
+}
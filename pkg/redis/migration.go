@@ -0,0 +1,106 @@
+package redis
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// scanCount is the number of keys or fields requested per SCAN iteration
+const scanCount = 1000
+
+// RateLimitCounterID returns the ID of the counter the rate limits state field belongs to
+func RateLimitCounterID(field string) string {
+	for _, algorithm := range []string{SlidingWindow, TokenBucket} {
+		if strings.HasSuffix(field, ":"+algorithm) {
+			return strings.TrimSuffix(field, ":"+algorithm)
+		}
+	}
+	return field
+}
+
+// MigrateRateLimits copies the state of the legacy rate limits hash into per-counter keys expiring after ttl.
+// groupOf returns the group of the counter stored in the hash.
+// Counters already stored in keys are not overwritten. Returns the number of migrated counters.
+func (r *RedisClient) MigrateRateLimits(groupOf func(counterID string) string, ttl time.Duration) (int, error) {
+	migrated := 0
+	err := r.scanHash(legacyRateLimitsKey, func(field, state string) error {
+		ok, err := r.rdb.SetNX(r.ctx, rateLimitKey(groupOf(RateLimitCounterID(field)), field), state, ttl).Result()
+		if ok {
+			migrated++
+		}
+		return err
+	})
+	return migrated, err
+}
+
+// CleanupLegacyRateLimits deletes the legacy rate limits hash, returns the number of deleted keys
+func (r *RedisClient) CleanupLegacyRateLimits() (int, error) {
+	keyType, err := r.rdb.Type(r.ctx, legacyRateLimitsKey).Result()
+	if err != nil || keyType != "hash" {
+		return 0, err
+	}
+
+	deleted, err := r.rdb.Unlink(r.ctx, legacyRateLimitsKey).Result()
+	return int(deleted), err
+}
+
+// scanHash calls fn for each field of the hash
+func (r *RedisClient) scanHash(key string, fn func(field, value string) error) error {
+	var cursor uint64
+	for {
+		values, next, err := r.rdb.HScan(r.ctx, key, cursor, "", scanCount).Result()
+		if err != nil {
+			return err
+		}
+		for i := 0; i+1 < len(values); i += 2 {
+			if err := fn(values[i], values[i+1]); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// scanKeys calls fn for each key matching the pattern of the type, on all masters of Redis Cluster
+func (r *RedisClient) scanKeys(match, keyType string, fn func(key string) error) error {
+	scan := func(ctx context.Context, client redis.UniversalClient) error {
+		var cursor uint64
+		for {
+			keys, next, err := client.ScanType(ctx, cursor, match, scanCount, keyType).Result()
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				if err := fn(key); err != nil {
+					return err
+				}
+			}
+			if next == 0 {
+				return nil
+			}
+			cursor = next
+		}
+	}
+
+	if clusterClient, ok := r.rdb.(*redis.ClusterClient); ok {
+		// masters are scanned concurrently
+		var mx sync.Mutex
+		serialized := fn
+		fn = func(key string) error {
+			mx.Lock()
+			defer mx.Unlock()
+			return serialized(key)
+		}
+		return clusterClient.ForEachMaster(r.ctx, func(ctx context.Context, master *redis.Client) error {
+			return scan(ctx, master)
+		})
+	}
+	return scan(r.ctx, r.rdb)
+}
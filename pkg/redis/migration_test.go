@@ -0,0 +1,66 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateRateLimits(t *testing.T) {
+	client, mr := setupTestRedis(t)
+	defer mr.Close()
+
+	mr.HSet(legacyRateLimitsKey, "project1", "100:5")
+	mr.HSet(legacyRateLimitsKey, "project1:token-bucket", "3.000000:1000")
+	mr.HSet(legacyRateLimitsKey, "workspace:ws1:sliding-window", "0:1:2")
+	mr.HSet(legacyRateLimitsKey, "project2", "100:7")
+
+	// counters stored in keys already are newer than the legacy state
+	require.NoError(t, mr.Set(rateLimitKey("project2", "project2"), "200:1"))
+
+	groupOf := func(counterID string) string {
+		if counterID == "project1" {
+			return "workspace:ws1"
+		}
+		return counterID
+	}
+	migrated, err := client.MigrateRateLimits(groupOf, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 3, migrated)
+
+	state, _ := mr.Get(rateLimitKey("workspace:ws1", "project1"))
+	assert.Equal(t, "100:5", state)
+	state, _ = mr.Get(rateLimitKey("workspace:ws1", "project1:token-bucket"))
+	assert.Equal(t, "3.000000:1000", state)
+	state, _ = mr.Get(rateLimitKey("workspace:ws1", "workspace:ws1:sliding-window"))
+	assert.Equal(t, "0:1:2", state)
+	state, _ = mr.Get(rateLimitKey("project2", "project2"))
+	assert.Equal(t, "200:1", state)
+	assert.Equal(t, time.Hour, mr.TTL(rateLimitKey("workspace:ws1", "project1")))
+
+	deleted, err := client.CleanupLegacyRateLimits()
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	assert.False(t, mr.Exists(legacyRateLimitsKey))
+	assert.True(t, mr.Exists(rateLimitKey("project2", "project2")))
+}
+
+func TestRateLimitKeysExpire(t *testing.T) {
+	client, mr := setupTestRedis(t)
+	defer mr.Close()
+
+	_, err := client.UpdateRateLimits(
+		RateLimit{ID: "project1", Limit: 10, Period: 60, Group: "workspace:ws1"},
+		RateLimit{ID: "workspace:ws1", Limit: 100, Period: 3600, Algorithm: TokenBucket},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, 60*time.Second, mr.TTL(rateLimitKey("workspace:ws1", "project1")))
+	assert.Equal(t, 36*time.Second, mr.TTL(rateLimitKey("workspace:ws1", "workspace:ws1:token-bucket")))
+
+	_, err = client.UpdateRateLimits(RateLimit{ID: "project2", Limit: 10, Period: 60, Algorithm: SlidingWindow})
+	require.NoError(t, err)
+	assert.InDelta(t, float64(120*time.Second), float64(mr.TTL(rateLimitKey("project2", "project2:sliding-window"))), float64(60*time.Second))
+}
@@ -0,0 +1,304 @@
+package redis
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Rate limiting algorithms which could be selected via rateLimitSettings
+const (
+	// FixedWindow counts events in a window started by the first event of the window
+	FixedWindow = "fixed-window"
+
+	// SlidingWindow estimates the number of events in the last period using the counters
+	// of the current and the previous aligned windows
+	SlidingWindow = "sliding-window"
+
+	// TokenBucket refills the bucket of eventsLimit tokens evenly during eventsPeriod
+	TokenBucket = "token-bucket"
+)
+
+// rateLimitsKey is the prefix of Redis keys with rate limits state.
+// The state of each counter is kept in its own key expiring with the window.
+// Limits checked together share their group as the hash tag, so the script touches a single slot on Redis Cluster.
+// Before that, the state was kept in legacyRateLimitsKey hash.
+const rateLimitsKey = "rate_limits"
+
+// legacyRateLimitsKey is the hash with rate limits state of all projects used by previous versions
+const legacyRateLimitsKey = rateLimitsKey
+
+// rateLimitKey returns the key with state of the counter field of the group
+func rateLimitKey(group, field string) string {
+	return rateLimitsKey + ":{" + group + "}:" + field
+}
+
+// rateLimitScript atomically checks and updates rate limits of several levels (e.g. project and workspace).
+// It reserves up to the requested number of events which fit into all levels, so a caller can lease
+// a chunk of quota at once. Events are counted only if none of the levels is exceeded,
+// so a rejected event doesn't consume quotas.
+// The state of each level is stored in its key with TTL until the state is not needed anymore, the format depends on the algorithm:
+//
+//	fixed-window:   "<window start, s>:<count>"
+//	sliding-window: "<window start, ms>:<previous window count>:<current window count>"
+//	token-bucket:   "<tokens left>:<last refill, ms>"
+//
+// Returns {0, reserved events, limit, remaining, reset} if all limits are respected,
+// otherwise {1-based index of the first exceeded level, 0, limit, 0, reset}.
+// limit, remaining and reset (seconds until the window ends or the bucket is full)
+// describe the most restrictive level. For an exceeded token bucket reset is the time until the next token,
+// since events are accepted again long before the bucket is full.
+const rateLimitScript = `
+	local now = tonumber(ARGV[1])
+	local requested = tonumber(ARGV[2])
+
+	-- Each algorithm returns the number of available events and a function which returns
+	-- the state after reserving n events, seconds until the limit is reset and seconds the state is needed for.
+	-- Algorithms may also return seconds until the next event is available if it's earlier than the reset.
+
+	local function fixed_window(state, limit, period)
+		local now_s = math.floor(now / 1000)
+		local new_window = function(n) return string.format('%d:%d', now_s, n), period, period end
+		if not state then
+			-- No existing record, create new window
+			return limit, new_window
+		end
+
+		local timestamp, count = string.match(state, '(%d+):(%d+)')
+		timestamp = tonumber(timestamp)
+		count = tonumber(count)
+
+		-- Check if we're in a new time window
+		if now_s - timestamp >= period then
+			return limit, new_window
+		end
+
+		return limit - count, function(n)
+			local reset = timestamp + period - now_s
+			return string.format('%d:%d', timestamp, count + n), reset, reset
+		end
+	end
+
+	local function sliding_window(state, limit, period)
+		local period_ms = period * 1000
+		local window = now - (now % period_ms)
+		local previous, current = 0, 0
+
+		if state then
+			local start, prev_count, cur_count = string.match(state, '(%d+):(%d+):(%d+)')
+			start = tonumber(start)
+			if start == window then
+				previous, current = tonumber(prev_count), tonumber(cur_count)
+			elseif start == window - period_ms then
+				-- The stored window has become the previous one
+				previous = tonumber(cur_count)
+			end
+		end
+
+		-- Part of the previous window which is still covered by the sliding window
+		local weight = (period_ms - (now - window)) / period_ms
+		local available = limit - math.floor(previous * weight) - current
+
+		return available, function(n)
+			-- the current window becomes the previous one for the next period
+			local reset = math.ceil((window + period_ms - now) / 1000)
+			return string.format('%d:%d:%d', window, previous, current + n), reset, reset + period
+		end
+	end
+
+	local function token_bucket(state, limit, period)
+		local tokens, last = limit, now
+		if state then
+			local stored_tokens, stored_last = string.match(state, '([%d%.]+):(%d+)')
+			tokens, last = tonumber(stored_tokens), tonumber(stored_last)
+		end
+
+		-- Refill tokens for the time passed since the last request
+		tokens = math.min(limit, tokens + math.max(0, now - last) * limit / (period * 1000))
+
+		return math.floor(tokens), function(n)
+			-- the bucket is full again after reset seconds, so the state could be dropped
+			local reset = math.ceil((limit - tokens + n) * period / limit)
+			return string.format('%.6f:%d', tokens - n, now), reset, reset
+		end, math.ceil((1 - tokens) * period / limit)
+	end
+
+	local algorithms = {
+		['fixed-window'] = fixed_window,
+		['sliding-window'] = sliding_window,
+		['token-bucket'] = token_bucket,
+	}
+
+	-- Each level is described by its key and 3 arguments: limit, period and algorithm
+	local levels = {}
+	local reserved = requested
+	for k, key in ipairs(KEYS) do
+		local i = 3 * k
+		local limit = tonumber(ARGV[i])
+		local period = tonumber(ARGV[i + 1])
+		local algorithm = ARGV[i + 2]
+
+		local available, state, retry = algorithms[algorithm](redis.call('GET', key), limit, period)
+		if available < 1 then
+			local _, reset = state(0)
+			return {k, 0, limit, 0, retry or reset}
+		end
+		reserved = math.min(reserved, available)
+
+		levels[#levels + 1] = {key = key, limit = limit, available = available, state = state}
+	end
+
+	local status = nil
+	for _, level in ipairs(levels) do
+		local state, reset, ttl = level.state(reserved)
+		redis.call('SET', level.key, state, 'EX', math.max(1, ttl))
+
+		local remaining = level.available - reserved
+		if not status or remaining < status[4] then
+			status = {0, reserved, level.limit, remaining, reset}
+		end
+	end
+
+	return status
+`
+
+// RateLimit describes a single level of rate limits hierarchy
+type RateLimit struct {
+	// ID is an unique identifier of the counter, e.g. project ID
+	ID string
+
+	// Limit is maximum number of events allowed in the Period, 0 means no limit
+	Limit int64
+
+	// Period is time window in seconds
+	Period int64
+
+	// Algorithm is one of FixedWindow, SlidingWindow or TokenBucket, empty and unknown values mean FixedWindow
+	Algorithm string
+
+	// Group is the hash tag shared by limits checked together, e.g. workspace ID.
+	// The first non-empty Group of the checked limits is used, otherwise the ID of the first one.
+	Group string
+}
+
+// rateLimitField returns the field of the counter state in the group.
+// Fixed window keeps ID as the field, so the state survives switching between versions.
+func rateLimitField(id, algorithm string) string {
+	if algorithm == FixedWindow {
+		return id
+	}
+	return id + ":" + algorithm
+}
+
+// UpdateRateLimit checks and updates the rate limit for a project using a Lua script.
+// algorithm is one of FixedWindow, SlidingWindow or TokenBucket, empty and unknown values mean FixedWindow.
+func (r *RedisClient) UpdateRateLimit(projectID string, eventsLimit int64, eventsPeriod int64, algorithm string) (bool, error) {
+	exceeded, err := r.UpdateRateLimits(RateLimit{ID: projectID, Limit: eventsLimit, Period: eventsPeriod, Algorithm: algorithm})
+	if err != nil {
+		return false, err
+	}
+	return exceeded == -1, nil
+}
+
+// RateLimitResult describes the result of rate limits check and the status of the most restrictive limit
+type RateLimitResult struct {
+	// Reserved is the number of events reserved in all limits
+	Reserved int64
+
+	// Exceeded is the index of the first exceeded limit or -1 if events are within all limits
+	Exceeded int
+
+	// Limit is maximum number of events of the most restrictive limit, 0 means no limit
+	Limit int64
+
+	// Remaining is the number of events left in the most restrictive limit
+	Remaining int64
+
+	// Reset is the number of seconds until the most restrictive limit is reset
+	Reset int64
+}
+
+// UpdateRateLimits atomically checks and updates several rate limits.
+// The event is counted by all of them only if none is exceeded.
+// Returns the index of the first exceeded limit or -1 if the event is within all limits.
+func (r *RedisClient) UpdateRateLimits(limits ...RateLimit) (int, error) {
+	result, err := r.ReserveRateLimits(1, limits...)
+	return result.Exceeded, err
+}
+
+// ReserveRateLimits atomically reserves up to n events which fit into all of the rate limits.
+// Nothing is reserved if any of the limits is exceeded.
+func (r *RedisClient) ReserveRateLimits(n int64, limits ...RateLimit) (RateLimitResult, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+
+	args := []interface{}{now, n}
+	indexes := make([]int, 0, len(limits))
+	fields := make([]string, 0, len(limits))
+	group := ""
+	for i, limit := range limits {
+		// If limit is 0, we don't need to update the rate limit
+		if limit.Limit == 0 {
+			continue
+		}
+
+		algorithm := limit.Algorithm
+		switch algorithm {
+		case "":
+			algorithm = FixedWindow
+		case FixedWindow, SlidingWindow, TokenBucket:
+		default:
+			// a typo in the settings must not reject all events of the project
+			log.Warnf("Unknown rate limit algorithm %q of %s, using %s", algorithm, limit.ID, FixedWindow)
+			algorithm = FixedWindow
+		}
+
+		args = append(args, limit.Limit, limit.Period, algorithm)
+		fields = append(fields, rateLimitField(limit.ID, algorithm))
+		indexes = append(indexes, i)
+		if group == "" {
+			group = limit.Group
+		}
+	}
+
+	if len(indexes) == 0 {
+		return RateLimitResult{Reserved: n, Exceeded: -1}, nil
+	}
+
+	if group == "" {
+		group = limits[indexes[0]].ID
+	}
+	keys := make([]string, len(fields))
+	for i, field := range fields {
+		keys[i] = rateLimitKey(group, field)
+	}
+
+	// Run the script
+	result, err := r.rdb.Eval(r.ctx, rateLimitScript, keys, args...).Result()
+	if err != nil {
+		return RateLimitResult{Exceeded: -1}, fmt.Errorf("failed to execute rate limit script: %w", err)
+	}
+
+	// Script returns {exceeded level or 0, reserved, limit, remaining, reset}
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 5 {
+		return RateLimitResult{Exceeded: -1}, fmt.Errorf("unexpected rate limit script result: %v", result)
+	}
+	numbers := make([]int64, len(values))
+	for i, value := range values {
+		numbers[i], _ = value.(int64)
+	}
+
+	status := RateLimitResult{
+		Reserved:  numbers[1],
+		Exceeded:  -1,
+		Limit:     numbers[2],
+		Remaining: numbers[3],
+		Reset:     numbers[4],
+	}
+	if numbers[0] != 0 {
+		status.Exceeded = indexes[numbers[0]-1]
+	}
+
+	return status, nil
+}
@@ -0,0 +1,160 @@
+package redis
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// tsMAddBatchSize is the maximum number of samples sent by a single TS.MADD command
+const tsMAddBatchSize = 1000
+
+// TSKey is a time series key created before the first write
+type TSKey struct {
+	Key       string
+	Labels    map[string]string
+	Retention time.Duration
+
+	// Rules compact samples of the key into destination keys created along with it
+	Rules []TSRule
+}
+
+// TSRule is a compaction rule summing samples of the source key into buckets of the destination key
+type TSRule struct {
+	Destination TSKey
+	Bucket      time.Duration
+}
+
+// TSKeySample is a sample of the time series key
+type TSKeySample struct {
+	Key string
+
+	// Timestamp in milliseconds
+	Timestamp int64
+	Value     int64
+}
+
+// TSCreateAll creates missing time series keys and their compaction rules in a pipeline.
+// Retention of existing keys is updated. Samples of all keys added at the same timestamp are summed up,
+// including keys created before by TS.ADD with ON_DUPLICATE, since TS.MADD has no per-command duplicate policy.
+func (r *RedisClient) TSCreateAll(keys []TSKey) error {
+	cmds, _ := r.rdb.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			r.pipeTSCreate(pipe, key)
+		}
+		return nil
+	})
+
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil && !isTSExistsError(err) {
+			return fmt.Errorf("failed to create TS: %w", err)
+		}
+	}
+	return nil
+}
+
+// pipeTSCreate adds commands creating the key, destinations and rules to the pipeline.
+// Errors of existing keys and rules must be ignored.
+func (r *RedisClient) pipeTSCreate(pipe redis.Pipeliner, key TSKey) {
+	for _, rule := range key.Rules {
+		r.pipeTSCreate(pipe, rule.Destination)
+	}
+
+	create := []interface{}{"TS.CREATE", key.Key}
+	alter := []interface{}{"TS.ALTER", key.Key}
+	if key.Retention > 0 {
+		create = append(create, "RETENTION", int64(key.Retention/time.Millisecond))
+		alter = append(alter, "RETENTION", int64(key.Retention/time.Millisecond))
+	}
+	create = append(create, "DUPLICATE_POLICY", "SUM", "LABELS")
+	for k, v := range key.Labels {
+		create = append(create, k, v)
+	}
+	alter = append(alter, "DUPLICATE_POLICY", "SUM")
+	pipe.Do(r.ctx, create...)
+	pipe.Do(r.ctx, alter...)
+
+	for _, rule := range key.Rules {
+		pipe.Do(r.ctx, "TS.CREATERULE", key.Key, rule.Destination.Key, "AGGREGATION", "SUM", int64(rule.Bucket/time.Millisecond))
+	}
+}
+
+// isTSExistsError returns true if the time series key or compaction rule already exists
+func isTSExistsError(err error) bool {
+	return strings.Contains(err.Error(), "already exists") || strings.Contains(err.Error(), "already has a")
+}
+
+// TSMAdd adds samples with TS.MADD commands in a pipeline and returns samples which were not added.
+// Keys must be created by TSCreateAll, otherwise their samples are rejected.
+func (r *RedisClient) TSMAdd(samples []TSKeySample) ([]TSKeySample, error) {
+	batches := tsMAddBatches(samples, r.IsCluster())
+
+	cmds := make([]*redis.Cmd, len(batches))
+	_, _ = r.rdb.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		for i, batch := range batches {
+			args := make([]interface{}, 0, 1+3*len(batch))
+			args = append(args, "TS.MADD")
+			for _, sample := range batch {
+				args = append(args, sample.Key, sample.Timestamp, sample.Value)
+			}
+			cmds[i] = pipe.Do(r.ctx, args...)
+		}
+		return nil
+	})
+
+	var failed []TSKeySample
+	var firstErr error
+	for i, cmd := range cmds {
+		reply, err := cmd.Result()
+		results, ok := reply.([]interface{})
+		if err == nil && !ok {
+			err = fmt.Errorf("unexpected TS.MADD result: %v", reply)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed = append(failed, batches[i]...)
+			continue
+		}
+		for j, result := range results {
+			if err, ok := result.(error); ok && j < len(batches[i]) {
+				if firstErr == nil {
+					firstErr = err
+				}
+				failed = append(failed, batches[i][j])
+			}
+		}
+	}
+	return failed, firstErr
+}
+
+// tsMAddBatches splits samples into TS.MADD commands.
+// Keys of a Cluster are in different slots, so there each command has samples of a single key.
+func tsMAddBatches(samples []TSKeySample, perKey bool) [][]TSKeySample {
+	var batches [][]TSKeySample
+	if perKey {
+		index := make(map[string]int)
+		for _, sample := range samples {
+			i, ok := index[sample.Key]
+			if !ok || len(batches[i]) == tsMAddBatchSize {
+				i = len(batches)
+				index[sample.Key] = i
+				batches = append(batches, nil)
+			}
+			batches[i] = append(batches[i], sample)
+		}
+		return batches
+	}
+
+	for start := 0; start < len(samples); start += tsMAddBatchSize {
+		end := start + tsMAddBatchSize
+		if end > len(samples) {
+			end = len(samples)
+		}
+		batches = append(batches, samples[start:end])
+	}
+	return batches
+}
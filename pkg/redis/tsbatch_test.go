@@ -0,0 +1,73 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTSMAddBatches(t *testing.T) {
+	samples := make([]TSKeySample, 0, tsMAddBatchSize+2)
+	for i := 0; i < tsMAddBatchSize; i++ {
+		samples = append(samples, TSKeySample{Key: "a", Timestamp: int64(i), Value: 1})
+	}
+	samples = append(samples, TSKeySample{Key: "b", Value: 1}, TSKeySample{Key: "a", Timestamp: tsMAddBatchSize, Value: 1})
+
+	batches := tsMAddBatches(samples, false)
+	assert.Len(t, batches, 2)
+	assert.Len(t, batches[0], tsMAddBatchSize)
+	assert.Equal(t, samples[tsMAddBatchSize:], batches[1])
+
+	batches = tsMAddBatches(samples, true)
+	assert.Len(t, batches, 3)
+	assert.Len(t, batches[0], tsMAddBatchSize)
+	assert.Equal(t, []TSKeySample{{Key: "b", Value: 1}}, batches[1])
+	assert.Equal(t, []TSKeySample{{Key: "a", Timestamp: tsMAddBatchSize, Value: 1}}, batches[2])
+
+	assert.Empty(t, tsMAddBatches(nil, false))
+}
+
+func TestTSCreateAll(t *testing.T) {
+	client, mr, fake := setupFakeTimeSeries(t)
+	defer mr.Close()
+
+	hourly := TSKey{Key: "ts:events:p1:hourly", Labels: map[string]string{"project": "p1"}, Retention: 7 * 24 * time.Hour}
+	minutely := TSKey{
+		Key:       "ts:events:p1:minutely",
+		Labels:    map[string]string{"project": "p1"},
+		Retention: 24 * time.Hour,
+		Rules:     []TSRule{{Destination: hourly, Bucket: time.Hour}},
+	}
+
+	require.NoError(t, client.TSCreateAll([]TSKey{minutely}))
+	assert.Equal(t, [][]string{
+		{"TS.CREATE", "ts:events:p1:hourly", "RETENTION", "604800000", "DUPLICATE_POLICY", "SUM", "LABELS", "project", "p1"},
+		{"TS.ALTER", "ts:events:p1:hourly", "RETENTION", "604800000", "DUPLICATE_POLICY", "SUM"},
+		{"TS.CREATE", "ts:events:p1:minutely", "RETENTION", "86400000", "DUPLICATE_POLICY", "SUM", "LABELS", "project", "p1"},
+		{"TS.ALTER", "ts:events:p1:minutely", "RETENTION", "86400000", "DUPLICATE_POLICY", "SUM"},
+		{"TS.CREATERULE", "ts:events:p1:minutely", "ts:events:p1:hourly", "AGGREGATION", "SUM", "3600000"},
+	}, fake.recorded())
+
+	// existing keys and rules are not an error
+	require.NoError(t, client.TSCreateAll([]TSKey{minutely}))
+	assert.Len(t, fake.recorded(), 5)
+}
+
+func TestTSMAdd(t *testing.T) {
+	client, mr, fake := setupFakeTimeSeries(t)
+	defer mr.Close()
+
+	require.NoError(t, client.TSCreateAll([]TSKey{{Key: "a"}}))
+	fake.recorded()
+
+	failed, err := client.TSMAdd([]TSKeySample{{Key: "a", Timestamp: 60000, Value: 3}, {Key: "missing", Timestamp: 60000, Value: 1}})
+	assert.Error(t, err)
+	assert.Equal(t, []TSKeySample{{Key: "missing", Timestamp: 60000, Value: 1}}, failed)
+	assert.Equal(t, [][]string{{"TS.MADD", "a", "60000", "3", "missing", "60000", "1"}}, fake.recorded())
+
+	failed, err = client.TSMAdd([]TSKeySample{{Key: "a", Timestamp: 120000, Value: 1}})
+	assert.NoError(t, err)
+	assert.Empty(t, failed)
+}
@@ -0,0 +1,106 @@
+package redis
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// tsKeyType is the type of RedisTimeSeries keys reported by SCAN
+const tsKeyType = "TSDB-TYPE"
+
+// tsInfo is a part of TS.INFO reply needed to migrate time series
+type tsInfo struct {
+	Labels map[string]string
+
+	// Rules are destination keys of existing compaction rules
+	Rules map[string]bool
+}
+
+// MigrateTSCompaction creates compaction rules for existing time series keys matching the pattern.
+// rulesOf returns rules of the source key, missing destinations are created with labels of the source.
+// Returns the number of created rules.
+func (r *RedisClient) MigrateTSCompaction(match string, rulesOf func(key string) []TSRule) (int, error) {
+	created := 0
+	err := r.scanKeys(match, tsKeyType, func(key string) error {
+		rules := rulesOf(key)
+		if len(rules) == 0 {
+			return nil
+		}
+
+		info, err := r.tsInfo(key)
+		if err != nil {
+			return err
+		}
+
+		var missing []TSRule
+		for _, rule := range rules {
+			if info.Rules[rule.Destination.Key] {
+				continue
+			}
+			if rule.Destination.Labels == nil {
+				rule.Destination.Labels = info.Labels
+			}
+			missing = append(missing, rule)
+		}
+		if len(missing) == 0 {
+			return nil
+		}
+
+		// the source retention is kept, only destinations and rules are created
+		cmds, _ := r.rdb.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+			for _, rule := range missing {
+				r.pipeTSCreate(pipe, rule.Destination)
+				pipe.Do(r.ctx, "TS.CREATERULE", key, rule.Destination.Key, "AGGREGATION", "SUM", int64(rule.Bucket/time.Millisecond))
+			}
+			return nil
+		})
+		for _, cmd := range cmds {
+			if err := cmd.Err(); err != nil && !isTSExistsError(err) {
+				return fmt.Errorf("failed to create compaction rules of %s: %w", key, err)
+			}
+		}
+		created += len(missing)
+		return nil
+	})
+	return created, err
+}
+
+// tsInfo returns labels and compaction rules of the time series key
+func (r *RedisClient) tsInfo(key string) (tsInfo, error) {
+	reply, err := r.rdb.Do(r.ctx, "TS.INFO", key).Result()
+	if err != nil {
+		return tsInfo{}, err
+	}
+	return parseTSInfo(reply)
+}
+
+// parseTSInfo parses labels and compaction rules of TS.INFO reply
+func parseTSInfo(reply interface{}) (tsInfo, error) {
+	info := tsInfo{Labels: make(map[string]string), Rules: make(map[string]bool)}
+
+	fields, ok := reply.([]interface{})
+	if !ok {
+		return info, fmt.Errorf("unexpected TS.INFO result: %v", reply)
+	}
+
+	for i := 0; i+1 < len(fields); i += 2 {
+		entries, _ := fields[i+1].([]interface{})
+		switch fmt.Sprint(fields[i]) {
+		case "labels":
+			for _, entry := range entries {
+				if pair, ok := entry.([]interface{}); ok && len(pair) == 2 {
+					info.Labels[fmt.Sprint(pair[0])] = fmt.Sprint(pair[1])
+				}
+			}
+		case "rules":
+			for _, entry := range entries {
+				if rule, ok := entry.([]interface{}); ok && len(rule) > 0 {
+					info.Rules[fmt.Sprint(rule[0])] = true
+				}
+			}
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,65 @@
+package redis
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTSInfo(t *testing.T) {
+	reply := []interface{}{
+		"totalSamples", int64(10),
+		"retentionTime", int64(86400000),
+		"labels", []interface{}{
+			[]interface{}{"type", "error"},
+			[]interface{}{"project", "p1"},
+		},
+		"sourceKey", nil,
+		"rules", []interface{}{
+			[]interface{}{"ts:project-events-accepted:p1:hourly", int64(3600000), "SUM"},
+		},
+	}
+
+	info, err := parseTSInfo(reply)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"type": "error", "project": "p1"}, info.Labels)
+	assert.Equal(t, map[string]bool{"ts:project-events-accepted:p1:hourly": true}, info.Rules)
+
+	_, err = parseTSInfo("OK")
+	assert.Error(t, err)
+}
+
+func TestMigrateTSCompaction(t *testing.T) {
+	client, mr, fake := setupFakeTimeSeries(t)
+	defer mr.Close()
+
+	require.NoError(t, client.TSCreateAll([]TSKey{
+		{Key: "ts:events:p1:minutely", Labels: map[string]string{"project": "p1"}},
+		{Key: "ts:events:p1:daily", Labels: map[string]string{"project": "p1"}},
+	}))
+	fake.recorded()
+
+	rulesOf := func(key string) []TSRule {
+		return []TSRule{{Destination: TSKey{Key: strings.TrimSuffix(key, ":minutely") + ":hourly", Retention: time.Hour}, Bucket: time.Hour}}
+	}
+	created, err := client.MigrateTSCompaction("ts:*:minutely", rulesOf)
+	require.NoError(t, err)
+	assert.Equal(t, 1, created)
+
+	// destinations are created with labels of the source
+	assert.Equal(t, [][]string{
+		{"TS.INFO", "ts:events:p1:minutely"},
+		{"TS.CREATE", "ts:events:p1:hourly", "RETENTION", "3600000", "DUPLICATE_POLICY", "SUM", "LABELS", "project", "p1"},
+		{"TS.ALTER", "ts:events:p1:hourly", "RETENTION", "3600000", "DUPLICATE_POLICY", "SUM"},
+		{"TS.CREATERULE", "ts:events:p1:minutely", "ts:events:p1:hourly", "AGGREGATION", "SUM", "3600000"},
+	}, fake.recorded())
+
+	// existing rules are skipped
+	created, err = client.MigrateTSCompaction("ts:*:minutely", rulesOf)
+	require.NoError(t, err)
+	assert.Equal(t, 0, created)
+	assert.Equal(t, [][]string{{"TS.INFO", "ts:events:p1:minutely"}}, fake.recorded())
+}
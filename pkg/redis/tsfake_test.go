@@ -0,0 +1,156 @@
+package redis
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+)
+
+// fakeTSKey is a time series key of fakeTimeSeries
+type fakeTSKey struct {
+	labels [][2]string
+	rules  [][2]string
+}
+
+// fakeTimeSeries emulates RedisTimeSeries commands used by the client on top of miniredis
+// and records their arguments, other commands are handled by miniredis
+type fakeTimeSeries struct {
+	mx       sync.Mutex
+	keys     map[string]*fakeTSKey
+	order    []string
+	commands [][]string
+}
+
+// setupFakeTimeSeries returns client connected to miniredis with emulated RedisTimeSeries commands
+func setupFakeTimeSeries(t *testing.T) (*RedisClient, *miniredis.Miniredis, *fakeTimeSeries) {
+	client, mr := setupTestRedis(t)
+	fake := &fakeTimeSeries{keys: make(map[string]*fakeTSKey)}
+	mr.Server().SetPreHook(fake.handle)
+	return client, mr, fake
+}
+
+// recorded returns arguments of time series commands and resets them
+func (f *fakeTimeSeries) recorded() [][]string {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	commands := f.commands
+	f.commands = nil
+	return commands
+}
+
+func (f *fakeTimeSeries) handle(c *server.Peer, cmd string, args ...string) bool {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	switch cmd {
+	case "TS.CREATE", "TS.ALTER", "TS.CREATERULE", "TS.MADD", "TS.INFO":
+		f.commands = append(f.commands, append([]string{cmd}, args...))
+	case "SCAN":
+		f.scan(c, args)
+		return true
+	default:
+		return false
+	}
+
+	switch cmd {
+	case "TS.CREATE":
+		if _, ok := f.keys[args[0]]; ok {
+			c.WriteError("ERR TSDB: key already exists")
+			return true
+		}
+		key := &fakeTSKey{}
+		for i, arg := range args {
+			if arg == "LABELS" {
+				for j := i + 1; j+1 < len(args); j += 2 {
+					key.labels = append(key.labels, [2]string{args[j], args[j+1]})
+				}
+			}
+		}
+		f.keys[args[0]] = key
+		f.order = append(f.order, args[0])
+		c.WriteOK()
+	case "TS.ALTER":
+		if _, ok := f.keys[args[0]]; !ok {
+			c.WriteError("ERR TSDB: the key does not exist")
+			return true
+		}
+		c.WriteOK()
+	case "TS.CREATERULE":
+		source, ok := f.keys[args[0]]
+		if !ok {
+			c.WriteError("ERR TSDB: the key does not exist")
+			return true
+		}
+		for _, rule := range source.rules {
+			if rule[0] == args[1] {
+				c.WriteError("ERR TSDB: the destination key already has a src rule")
+				return true
+			}
+		}
+		source.rules = append(source.rules, [2]string{args[1], args[4]})
+		c.WriteOK()
+	case "TS.MADD":
+		c.WriteLen(len(args) / 3)
+		for i := 0; i+2 < len(args); i += 3 {
+			if _, ok := f.keys[args[i]]; !ok {
+				c.WriteError("ERR TSDB: the key does not exist")
+				continue
+			}
+			timestamp, _ := strconv.Atoi(args[i+1])
+			c.WriteInt(timestamp)
+		}
+	case "TS.INFO":
+		key, ok := f.keys[args[0]]
+		if !ok {
+			c.WriteError("ERR TSDB: the key does not exist")
+			return true
+		}
+		c.WriteLen(4)
+		c.WriteBulk("labels")
+		c.WriteLen(len(key.labels))
+		for _, label := range key.labels {
+			c.WriteStrings(label[:])
+		}
+		c.WriteBulk("rules")
+		c.WriteLen(len(key.rules))
+		for _, rule := range key.rules {
+			c.WriteLen(3)
+			c.WriteBulk(rule[0])
+			bucket, _ := strconv.Atoi(rule[1])
+			c.WriteInt(bucket)
+			c.WriteBulk("SUM")
+		}
+	}
+	return true
+}
+
+// scan returns all time series keys matching the pattern in a single iteration
+func (f *fakeTimeSeries) scan(c *server.Peer, args []string) {
+	match, keyType := "*", ""
+	for i := 1; i+1 < len(args); i += 2 {
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			match = args[i+1]
+		case "TYPE":
+			keyType = args[i+1]
+		}
+	}
+
+	var keys []string
+	if keyType == tsKeyType {
+		for _, key := range f.order {
+			if ok, _ := filepath.Match(match, key); ok {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	c.WriteLen(2)
+	c.WriteBulk("0")
+	c.WriteStrings(keys)
+}
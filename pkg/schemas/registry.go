@@ -0,0 +1,99 @@
+package schemas
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// Validation modes
+const (
+	// Enforce rejects payloads which don't match the schema
+	Enforce = "enforce"
+
+	// WarnOnly accepts payloads which don't match the schema and records violations
+	WarnOnly = "warn"
+)
+
+// maxViolations is the maximum number of violations reported for a payload
+const maxViolations = 5
+
+// Registry contains JSON Schemas of payloads per catcher type
+type Registry struct {
+	// Mode is Enforce or WarnOnly
+	Mode string
+
+	schemas map[string]*gojsonschema.Schema
+}
+
+// Load compiles schemas from the directory. Catcher type is the path of the file relative to the directory
+// without .json extension, e.g. errors/javascript.json is the schema of errors/javascript payloads.
+func Load(dir, mode string) (*Registry, error) {
+	if mode != Enforce && mode != WarnOnly {
+		return nil, fmt.Errorf("unknown schema validation mode: %s", mode)
+	}
+
+	registry := &Registry{Mode: mode, schemas: make(map[string]*gojsonschema.Schema)}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+
+		relative, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		catcherType := filepath.ToSlash(strings.TrimSuffix(relative, ".json"))
+
+		absolute, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		schema, err := gojsonschema.NewSchema(gojsonschema.NewReferenceLoader("file://" + filepath.ToSlash(absolute)))
+		if err != nil {
+			return fmt.Errorf("failed to load schema of %s: %w", catcherType, err)
+		}
+
+		registry.schemas[catcherType] = schema
+		log.Infof("✓ JSON Schema of %s loaded", catcherType)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return registry, nil
+}
+
+// Validate returns violations of the payload schema of the catcher type as "path: description" strings.
+// Payloads of catcher types without schemas are always valid.
+func (registry *Registry) Validate(catcherType string, payload []byte) []string {
+	if registry == nil {
+		return nil
+	}
+	schema, ok := registry.schemas[catcherType]
+	if !ok {
+		return nil
+	}
+
+	result, err := schema.Validate(gojsonschema.NewBytesLoader(payload))
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var violations []string
+	for _, violation := range result.Errors() {
+		if len(violations) == maxViolations {
+			break
+		}
+		violations = append(violations, fmt.Sprintf("%s: %s", violation.Field(), violation.Description()))
+	}
+	return violations
+}
@@ -0,0 +1,28 @@
+package schemas
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	registry, err := Load("testdata", Enforce)
+	require.NoError(t, err)
+
+	assert.Empty(t, registry.Validate("errors/javascript", []byte(`{"title":"TypeError","backtrace":[{"file":"app.js","line":1}]}`)))
+	assert.Empty(t, registry.Validate("errors/golang", []byte(`{"backtrace":"not validated"}`)))
+
+	assert.Equal(t, []string{"(root): title is required"}, registry.Validate("errors/javascript", []byte(`{}`)))
+	assert.Equal(t, []string{"backtrace.0.line: Invalid type. Expected: integer, given: string"},
+		registry.Validate("errors/javascript", []byte(`{"title":"TypeError","backtrace":[{"file":"app.js","line":"1"}]}`)))
+
+	var empty *Registry
+	assert.Empty(t, empty.Validate("errors/javascript", []byte(`{}`)))
+}
+
+func TestLoadUnknownMode(t *testing.T) {
+	_, err := Load("testdata", "strict")
+	assert.Error(t, err)
+}
@@ -0,0 +1,328 @@
+package scrubber
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+// Redacted replaces sensitive values
+const Redacted = "[Filtered]"
+
+// sensitiveKeys matches keys which values are always redacted
+var sensitiveKeys = regexp.MustCompile(`(?i)(passw(or)?d|^pwd$|secret|token|api[-_]?key|authorization|^auth$|cookie|session|credentials|^card[-_]?number$|^cvv$|^iban$)`)
+
+// cardPrefixes matches issuer identification numbers of payment card networks,
+// so unformatted numbers like millisecond timestamps starting with 1 are not taken for cards
+var cardPrefixes = regexp.MustCompile(`^(?:4|5[1-5]|2[2-7]|3[045678]|6)`)
+
+// Built-in detectors of sensitive data in string values
+var (
+	creditCards = regexp.MustCompile(`\b(?:\d{4}[ -]\d{4,6}[ -]\d{4,5}(?:[ -]\d{1,4})?|\d{13,19})\b`)
+	ibans       = regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]){11,30}\b`)
+	emails      = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	authHeaders = regexp.MustCompile(`(?i)\b(Bearer|Basic|Digest|Token) [A-Za-z0-9\-._~+/]+=*`)
+	passwords   = regexp.MustCompile(`(?i)\b(passw(or)?d|pwd|secret|token|api[-_]?key)=([^&\s]+)`)
+)
+
+// Settings is the scrubbing configuration stored in the project document
+type Settings struct {
+	// Enabled turns on built-in detectors of credit cards, emails, IBANs, auth headers, cookies and passwords
+	Enabled bool `bson:"enabled"`
+
+	// Paths are dot separated JSON paths of redacted values, "*" matches any key or array index
+	Paths []string `bson:"paths"`
+
+	// Keys are regular expressions of redacted keys
+	Keys []string `bson:"keys"`
+}
+
+// Configured returns true if built-in detectors or project rules are enabled
+func (settings Settings) Configured() bool {
+	return settings.Enabled || len(settings.Paths) > 0 || len(settings.Keys) > 0
+}
+
+// Scrubber redacts sensitive data of a project payloads
+type Scrubber struct {
+	// builtin enables built-in detectors
+	builtin bool
+
+	paths [][]string
+	keys  []*regexp.Regexp
+}
+
+// New compiles the scrubber, invalid regular expressions are skipped
+func New(settings Settings) *Scrubber {
+	scrubber := &Scrubber{builtin: settings.Enabled}
+	for _, path := range settings.Paths {
+		scrubber.paths = append(scrubber.paths, strings.Split(path, "."))
+	}
+	for _, pattern := range settings.Keys {
+		expression, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Warnf("Invalid scrubbing key pattern %q: %s", pattern, err)
+			continue
+		}
+		scrubber.keys = append(scrubber.keys, expression)
+	}
+	return scrubber
+}
+
+// Scrub returns the JSON payload with sensitive values redacted and the number of redacted values.
+// Order of keys and formatting of untouched values are preserved.
+func (scrubber *Scrubber) Scrub(payload []byte) ([]byte, int) {
+	var buffer bytes.Buffer
+	redacted := scrubber.scrub(&buffer, gjson.ParseBytes(payload), nil)
+	if redacted == 0 {
+		return payload, 0
+	}
+	return buffer.Bytes(), redacted
+}
+
+func (scrubber *Scrubber) scrub(buffer *bytes.Buffer, value gjson.Result, path []string) int {
+	if len(path) > 0 && scrubber.isSensitive(path) {
+		writeString(buffer, Redacted)
+		return 1
+	}
+
+	redacted := 0
+	switch {
+	case value.IsObject():
+		buffer.WriteByte('{')
+		first := true
+		value.ForEach(func(key, item gjson.Result) bool {
+			if !first {
+				buffer.WriteByte(',')
+			}
+			first = false
+			buffer.WriteString(key.Raw)
+			buffer.WriteByte(':')
+			redacted += scrubber.scrub(buffer, item, append(path, key.String()))
+			return true
+		})
+		buffer.WriteByte('}')
+	case value.IsArray():
+		buffer.WriteByte('[')
+		for i, item := range value.Array() {
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+			redacted += scrubber.scrub(buffer, item, append(path, strconv.Itoa(i)))
+		}
+		buffer.WriteByte(']')
+	case value.Type == gjson.String && scrubber.builtin:
+		scrubbed, count := scrubString(value.Str)
+		if count == 0 {
+			buffer.WriteString(value.Raw)
+		} else {
+			writeString(buffer, scrubbed)
+		}
+		redacted += count
+	case value.Type == gjson.Number && scrubber.builtin && isCreditCard(value.Raw):
+		writeString(buffer, Redacted)
+		redacted++
+	default:
+		buffer.WriteString(value.Raw)
+	}
+	return redacted
+}
+
+// isSensitive returns true if the value at the path is redacted by its key or by project paths
+func (scrubber *Scrubber) isSensitive(path []string) bool {
+	key := path[len(path)-1]
+	if scrubber.builtin && sensitiveKeys.MatchString(key) {
+		return true
+	}
+	for _, expression := range scrubber.keys {
+		if expression.MatchString(key) {
+			return true
+		}
+	}
+	for _, pattern := range scrubber.paths {
+		if matchPath(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath returns true if the path matches the pattern segments
+func matchPath(pattern, path []string) bool {
+	if len(pattern) != len(path) {
+		return false
+	}
+	for i := range pattern {
+		if pattern[i] != "*" && pattern[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// scrubString redacts substrings found by built-in detectors
+func scrubString(value string) (string, int) {
+	redacted := 0
+	replace := func(expression *regexp.Regexp, valid func(string) bool) {
+		value = expression.ReplaceAllStringFunc(value, func(match string) string {
+			if valid != nil && !valid(match) {
+				return match
+			}
+			redacted++
+			return Redacted
+		})
+	}
+
+	replace(authHeaders, nil)
+	replace(emails, nil)
+	replace(creditCards, isCreditCard)
+	replace(ibans, isIBAN)
+
+	value = passwords.ReplaceAllStringFunc(value, func(match string) string {
+		redacted++
+		return match[:strings.IndexByte(match, '=')+1] + Redacted
+	})
+
+	return value, redacted
+}
+
+// isCreditCard checks the number of digits and the Luhn checksum.
+// Numbers without separators must start with a known card network prefix.
+func isCreditCard(value string) bool {
+	digits := make([]int, 0, 19)
+	separated := false
+	for _, char := range value {
+		switch {
+		case char >= '0' && char <= '9':
+			digits = append(digits, int(char-'0'))
+		case char == ' ' || char == '-':
+			separated = true
+		default:
+			return false
+		}
+	}
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	if !separated && !cardPrefixes.MatchString(value) {
+		return false
+	}
+
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		digit := digits[i]
+		if (len(digits)-i)%2 == 0 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
+
+// isIBAN checks the ISO 13616 mod 97 checksum
+func isIBAN(value string) bool {
+	value = strings.ReplaceAll(value, " ", "")
+	rearranged := value[4:] + value[:4]
+
+	var numeric strings.Builder
+	for _, char := range rearranged {
+		if char >= 'A' && char <= 'Z' {
+			numeric.WriteString(strconv.Itoa(int(char-'A') + 10))
+		} else {
+			numeric.WriteRune(char)
+		}
+	}
+
+	number, ok := new(big.Int).SetString(numeric.String(), 10)
+	return ok && new(big.Int).Mod(number, big.NewInt(97)).Int64() == 1
+}
+
+// writeString writes JSON encoded string without escaping HTML characters
+func writeString(buffer *bytes.Buffer, value string) {
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(value)
+
+	// Encode appends a newline
+	buffer.Truncate(buffer.Len() - 1)
+}
+
+// ScrubEnvelope redacts sensitive data of JSON items of Sentry envelope.
+// Item headers with explicit length are updated to the length of the scrubbed payload, attachments are kept untouched.
+func (scrubber *Scrubber) ScrubEnvelope(envelope []byte) ([]byte, int) {
+	end := bytes.IndexByte(envelope, '\n')
+	if end == -1 {
+		return envelope, 0
+	}
+
+	var buffer bytes.Buffer
+	buffer.Write(envelope[:end+1])
+	rest := envelope[end+1:]
+
+	redacted := 0
+	for len(rest) > 0 {
+		end = bytes.IndexByte(rest, '\n')
+		if end == -1 {
+			buffer.Write(rest)
+			break
+		}
+		header := rest[:end]
+		rest = rest[end+1:]
+
+		length := gjson.GetBytes(header, "length")
+		size := bytes.IndexByte(rest, '\n')
+		if length.Exists() {
+			size = int(length.Int())
+		}
+		if size < 0 || size > len(rest) {
+			size = len(rest)
+		}
+		payload := rest[:size]
+		rest = rest[size:]
+
+		count := 0
+		if gjson.GetBytes(header, "type").String() != "attachment" && gjson.ValidBytes(payload) {
+			payload, count = scrubber.Scrub(payload)
+		}
+		if count > 0 && length.Exists() {
+			header = withLength(header, len(payload))
+		}
+		redacted += count
+
+		buffer.Write(header)
+		buffer.WriteByte('\n')
+		buffer.Write(payload)
+		if len(rest) > 0 && rest[0] == '\n' {
+			buffer.WriteByte('\n')
+			rest = rest[1:]
+		}
+	}
+
+	if redacted == 0 {
+		return envelope, 0
+	}
+	return buffer.Bytes(), redacted
+}
+
+// withLength returns the item header with the length replaced
+func withLength(header []byte, length int) []byte {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(header, &fields); err != nil {
+		return header
+	}
+	fields["length"] = length
+
+	updated, err := json.Marshal(fields)
+	if err != nil {
+		return header
+	}
+	return updated
+}
@@ -0,0 +1,111 @@
+package scrubber
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestScrub(t *testing.T) {
+	scrubber := New(Settings{Enabled: true, Paths: []string{"user.*.name", "addons.window"}, Keys: []string{"^ssn$"}})
+
+	payload := []byte(`{"title":"Failed for john@example.com","timestamp":1545203808,` +
+		`"context":{"password":"qwerty","Cookie":"sid=1","ssn":"123","card":4111111111111111,"query":"page=1&token=abc"},` +
+		`"headers":{"Authorization":"Bearer abc.def"},"message":"Paid with 4111 1111 1111 1111 from DE89 3704 0044 0532 0130 00",` +
+		`"auth":"Basic dXNlcjpwYXNz","user":{"a":{"name":"John","id":1}},"addons":{"window":{"w":1},"url":"https://example.com"},` +
+		`"order":"1234567890123"}`)
+
+	scrubbed, redacted := scrubber.Scrub(payload)
+	assert.True(t, gjson.ValidBytes(scrubbed))
+
+	values := gjson.GetManyBytes(scrubbed,
+		"title", "timestamp", "context.password", "context.Cookie", "context.ssn", "context.card", "context.query",
+		"headers.Authorization", "message", "auth", "user.a.name", "user.a.id", "addons.window", "addons.url", "order",
+	)
+	assert.Equal(t, "Failed for [Filtered]", values[0].String())
+	assert.Equal(t, int64(1545203808), values[1].Int())
+	assert.Equal(t, Redacted, values[2].String())
+	assert.Equal(t, Redacted, values[3].String())
+	assert.Equal(t, Redacted, values[4].String())
+	assert.Equal(t, Redacted, values[5].String())
+	assert.Equal(t, "page=1&token=[Filtered]", values[6].String())
+	assert.Equal(t, Redacted, values[7].String())
+	assert.Equal(t, "Paid with [Filtered] from [Filtered]", values[8].String())
+	assert.Equal(t, Redacted, values[9].String())
+	assert.Equal(t, Redacted, values[10].String())
+	assert.Equal(t, int64(1), values[11].Int())
+	assert.Equal(t, Redacted, values[12].String())
+	assert.Equal(t, "https://example.com", values[13].String())
+
+	// not a valid card number by Luhn checksum
+	assert.Equal(t, "1234567890123", values[14].String())
+	assert.Equal(t, 12, redacted)
+}
+
+func TestScrubKeepsTimestamps(t *testing.T) {
+	scrubber := New(Settings{Enabled: true})
+
+	// millisecond timestamps passing Luhn checksum
+	for _, timestamp := range []string{"1700000000004", "1712345678907", "1699999999996"} {
+		assert.True(t, isLuhnValid(timestamp), timestamp)
+
+		payload := []byte(`{"timestamp":` + timestamp + `,"context":{"now":"` + timestamp + `","message":"started at ` + timestamp + `"}}`)
+		scrubbed, redacted := scrubber.Scrub(payload)
+		assert.Equal(t, 0, redacted, timestamp)
+		assert.Equal(t, string(payload), string(scrubbed))
+	}
+
+	// unformatted card numbers are detected by network prefix
+	scrubbed, redacted := scrubber.Scrub([]byte(`{"message":"card 4111111111111111","amex":"378282246310005","number":5555555555554444}`))
+	assert.Equal(t, 3, redacted)
+	assert.Equal(t, `{"message":"card [Filtered]","amex":"[Filtered]","number":"[Filtered]"}`, string(scrubbed))
+}
+
+// isLuhnValid checks the Luhn checksum of the digits
+func isLuhnValid(digits string) bool {
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		digit := int(digits[i] - '0')
+		if (len(digits)-i)%2 == 0 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
+
+func TestScrubUntouched(t *testing.T) {
+	payload := []byte(`{"title": "TypeError", "backtrace": [ {"file": "app.js", "line": 1} ]}`)
+
+	scrubbed, redacted := New(Settings{Enabled: true}).Scrub(payload)
+	assert.Equal(t, 0, redacted)
+	assert.Equal(t, string(payload), string(scrubbed))
+}
+
+func TestScrubEnvelope(t *testing.T) {
+	event := `{"message":"user john@example.com"}`
+	envelope := []byte("{\"event_id\":\"1\"}\n" +
+		"{\"type\":\"event\",\"length\":" + "35" + "}\n" + event + "\n" +
+		"{\"type\":\"attachment\",\"length\":10}\nmail@a.io\n\n" +
+		"{\"type\":\"session\"}\n{\"sid\":\"1\",\"email\":\"a@b.io\"}\n")
+	assert.Equal(t, 35, len(event))
+
+	scrubbed, redacted := New(Settings{Enabled: true}).ScrubEnvelope(envelope)
+	assert.Equal(t, 2, redacted)
+	assert.Equal(t, "{\"event_id\":\"1\"}\n"+
+		"{\"length\":29,\"type\":\"event\"}\n{\"message\":\"user [Filtered]\"}\n"+
+		"{\"type\":\"attachment\",\"length\":10}\nmail@a.io\n\n"+
+		"{\"type\":\"session\"}\n{\"sid\":\"1\",\"email\":\"[Filtered]\"}\n", string(scrubbed))
+}
+
+func TestScrubProjectRulesOnly(t *testing.T) {
+	payload := []byte(`{"password":"qwerty","email":"john@example.com","user":{"name":"John"}}`)
+
+	scrubbed, redacted := New(Settings{Paths: []string{"user.name"}}).Scrub(payload)
+	assert.Equal(t, 1, redacted)
+	assert.Equal(t, `{"password":"qwerty","email":"john@example.com","user":{"name":"[Filtered]"}}`, string(scrubbed))
+}
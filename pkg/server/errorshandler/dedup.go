@@ -0,0 +1,104 @@
+package errorshandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"github.com/codex-team/hawk.collector/pkg/broker"
+	"github.com/codex-team/hawk.collector/pkg/dedup"
+	log "github.com/sirupsen/logrus"
+)
+
+// RepeatsQueueName is the route of repeat counts of deduplicated events
+const RepeatsQueueName = "errors/repeats"
+
+// GetFingerprintPaths - construct fingerprint gjson paths per catcher type from "catcherType=path1,path2" entries
+func GetFingerprintPaths(entries []string) map[string][]string {
+	paths := make(map[string][]string)
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			log.Warnf("Invalid fingerprint paths entry: %s", entry)
+			continue
+		}
+		paths[parts[0]] = strings.Split(parts[1], ",")
+	}
+	return paths
+}
+
+// fingerprintPaths returns gjson paths identifying the error of the catcher type
+func (handler *Handler) fingerprintPaths(catcherType string) []string {
+	if paths, ok := handler.FingerprintPaths[catcherType]; ok {
+		return paths
+	}
+	if catcherType == CatcherType {
+		return sentryFingerprintPaths
+	}
+	return defaultFingerprintPaths
+}
+
+// eventFingerprint returns the hash of the event fingerprint fields.
+// Returns false if the payload has none of the fingerprint fields, so distinct events are not collapsed.
+func (handler *Handler) eventFingerprint(catcherType string, payload []byte) (string, bool) {
+	fingerprint := payloadFingerprint(payload, handler.fingerprintPaths(catcherType))
+	if fingerprint == nil {
+		return "", false
+	}
+
+	hash := fnv.New128a()
+	_, _ = hash.Write(fingerprint)
+	return fmt.Sprintf("%x", hash.Sum(nil)), true
+}
+
+// deduplicate returns true if the message is a duplicate of an event sent within the deduplication window
+// and should not be sent to the broker. Only projects which opted in are deduplicated.
+// The first event of the window gets the fingerprint its repeat count is sent with later.
+// Suppressed duplicates are recorded as "events-deduplicated" metric.
+func (handler *Handler) deduplicate(message *BrokerMessage, payload []byte) bool {
+	if handler.Deduplicator == nil || !handler.AccountsMongoDBClient.IsDeduplicationEnabled(message.ProjectId) {
+		return false
+	}
+
+	fingerprint, ok := handler.eventFingerprint(message.CatcherType, payload)
+	if !ok {
+		return false
+	}
+
+	key := message.ProjectId + ":" + message.CatcherType + ":" + fingerprint
+	repeats := RepeatsMessage{
+		ProjectId:       message.ProjectId,
+		CatcherType:     message.CatcherType,
+		Fingerprint:     fingerprint,
+		FirstReceivedAt: message.ReceivedAt,
+	}
+	if !handler.Deduplicator.Check(key, repeats) {
+		message.Fingerprint = fingerprint
+		return false
+	}
+
+	log.Debugf("Duplicate event of project %s is suppressed", message.ProjectId)
+	handler.recordProjectMetrics(message.ProjectId, "events-deduplicated", false)
+	return true
+}
+
+// ForwardRepeated returns the release function for the deduplicator
+// which sends the number of duplicates suppressed after the first event of the window to the repeats queue
+func ForwardRepeated(brokerClient *broker.Broker) func(*dedup.Entry) {
+	return func(entry *dedup.Entry) {
+		repeats := entry.Value.(RepeatsMessage)
+		repeats.RepeatCount = entry.Repeats
+		repeats.LastReceivedAt = entry.LastSeen.UnixNano() / int64(time.Millisecond)
+
+		rawMessage, err := json.Marshal(repeats)
+		if err != nil {
+			log.Errorf("Message marshalling error: %v", err)
+			return
+		}
+
+		log.Debugf("Send %d repeats of project %s event to queue: %s", entry.Repeats, repeats.ProjectId, RepeatsQueueName)
+		brokerClient.Chan <- broker.Message{Payload: rawMessage, Route: RepeatsQueueName}
+	}
+}
@@ -0,0 +1,43 @@
+package errorshandler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/codex-team/hawk.collector/pkg/broker"
+	"github.com/codex-team/hawk.collector/pkg/dedup"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventFingerprint(t *testing.T) {
+	handler := &Handler{}
+
+	first, ok := handler.eventFingerprint("errors/javascript", []byte(`{"title":"TypeError","timestamp":1}`))
+	assert.True(t, ok)
+	second, ok := handler.eventFingerprint("errors/javascript", []byte(`{"title":"TypeError","timestamp":2}`))
+	assert.True(t, ok)
+	assert.Equal(t, first, second)
+
+	// events without fingerprint fields are never deduplicated
+	_, ok = handler.eventFingerprint("errors/javascript", []byte(`{"context":{"a":1}}`))
+	assert.False(t, ok)
+
+	_, ok = handler.eventFingerprint(CatcherType, []byte(`{"type":"transaction","transaction":"/api"}`))
+	assert.False(t, ok)
+}
+
+func TestForwardRepeated(t *testing.T) {
+	brokerClient := &broker.Broker{Chan: make(chan broker.Message, 1)}
+	lastSeen := time.Unix(1700000000, 0)
+
+	ForwardRepeated(brokerClient)(&dedup.Entry{
+		Value:    RepeatsMessage{ProjectId: "p1", CatcherType: "errors/javascript", Fingerprint: "abc", FirstReceivedAt: 1699999990000},
+		Repeats:  42,
+		LastSeen: lastSeen,
+	})
+
+	// only the repeat count is sent, the first event was sent already
+	message := <-brokerClient.Chan
+	assert.Equal(t, RepeatsQueueName, message.Route)
+	assert.JSONEq(t, `{"projectId":"p1","catcherType":"errors/javascript","fingerprint":"abc","repeatCount":42,"firstReceivedAt":1699999990000,"lastReceivedAt":1700000000000}`, string(message.Payload))
+}
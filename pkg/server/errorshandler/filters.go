@@ -0,0 +1,125 @@
+package errorshandler
+
+import (
+	"github.com/codex-team/hawk.collector/pkg/clientip"
+	"github.com/codex-team/hawk.collector/pkg/filters"
+	"github.com/codex-team/hawk.collector/pkg/tsmetrics"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"github.com/valyala/fasthttp"
+)
+
+// RequestInfo contains data about the client used by inbound filters and enrichment
+type RequestInfo struct {
+	// IP is the client IP determined by the server
+	IP string
+
+	UserAgent string
+
+	// Origin is the origin or referer of the page the event is sent from
+	Origin string
+}
+
+// getRequestInfo extracts the client IP and headers from the request
+func getRequestInfo(ctx *fasthttp.RequestCtx) RequestInfo {
+	origin := string(ctx.Request.Header.Peek("Origin"))
+	if origin == "" {
+		origin = string(ctx.Request.Header.Referer())
+	}
+	ip, _ := ctx.UserValue(clientip.UserValueKey).(string)
+	return RequestInfo{IP: ip, UserAgent: string(ctx.Request.Header.UserAgent()), Origin: origin}
+}
+
+// stringValues collects strings from the gjson result flattening nested arrays
+func stringValues(result gjson.Result, values []string) []string {
+	if result.IsArray() {
+		for _, item := range result.Array() {
+			values = stringValues(item, values)
+		}
+		return values
+	}
+	if result.String() != "" {
+		values = append(values, result.String())
+	}
+	return values
+}
+
+// getFilterEvent extracts fields checked by filters from Hawk catchers payload.
+// Fields of the payload take precedence over request headers, since events may be sent by a proxy.
+func getFilterEvent(payload []byte, request RequestInfo) filters.Event {
+	fields := gjson.GetManyBytes(payload, "title", "backtrace.#.file", "release", "addons.userAgent", "addons.url")
+	event := filters.Event{
+		Messages:  stringValues(fields[0], nil),
+		Files:     stringValues(fields[1], nil),
+		Release:   fields[2].String(),
+		UserAgent: fields[3].String(),
+		URL:       fields[4].String(),
+	}
+	if event.UserAgent == "" {
+		event.UserAgent = request.UserAgent
+	}
+	if event.URL == "" {
+		event.URL = request.Origin
+	}
+	return event
+}
+
+// getSentryFilterEvent extracts fields checked by filters from Sentry event
+func getSentryFilterEvent(payload []byte, request RequestInfo) filters.Event {
+	fields := gjson.GetManyBytes(payload,
+		"exception.values.#.value", "message", "logentry.message",
+		"exception.values.#.stacktrace.frames.#.filename",
+		"release", "request.headers.User-Agent", "request.url",
+	)
+	event := filters.Event{
+		Messages:  stringValues(fields[2], stringValues(fields[1], stringValues(fields[0], nil))),
+		Files:     stringValues(fields[3], nil),
+		Release:   fields[4].String(),
+		UserAgent: fields[5].String(),
+		URL:       fields[6].String(),
+	}
+	if event.UserAgent == "" {
+		event.UserAgent = request.UserAgent
+	}
+	if event.URL == "" {
+		event.URL = request.Origin
+	}
+	return event
+}
+
+// applyFilters returns false if the event is dropped by inbound filters of the project.
+// Fields of the payload are extracted by getEvent only if the project has filters.
+// Filtered events are recorded as "events-filtered" metric in total and per reason.
+func (handler *Handler) applyFilters(projectId string, payload []byte, request RequestInfo, getEvent func([]byte, RequestInfo) filters.Event) bool {
+	filter, ok := handler.AccountsMongoDBClient.GetProjectFilter(projectId)
+	if !ok {
+		return true
+	}
+
+	reason, filtered := filter.Check(getEvent(payload, request))
+	if !filtered {
+		return true
+	}
+
+	log.Debugf("Event of project %s is filtered: %s", projectId, reason)
+	handler.recordProjectMetrics(projectId, "events-filtered", false)
+	handler.recordFilteredMetrics(projectId, reason)
+	return false
+}
+
+// recordFilteredMetrics records events dropped by inbound filters to Redis TimeSeries per reason
+func (handler *Handler) recordFilteredMetrics(projectId, reason string) {
+	metricType := "events-filtered-" + reason
+	minutelyKey := tsmetrics.ProjectKey(projectId, metricType, "minutely", false)
+	hourlyKey := tsmetrics.ProjectKey(projectId, metricType, "hourly", false)
+	dailyKey := tsmetrics.ProjectKey(projectId, metricType, "daily", false)
+
+	labels := map[string]string{
+		"type":    "error",
+		"status":  "events-filtered",
+		"reason":  reason,
+		"project": projectId,
+	}
+
+	handler.recordTimeSeries(minutelyKey, hourlyKey, dailyKey, labels, 1)
+}
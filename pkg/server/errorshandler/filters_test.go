@@ -0,0 +1,30 @@
+package errorshandler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSentryFilterEvent(t *testing.T) {
+	payload := []byte(`{"release":"1.2.0","message":"boom","exception":{"values":[` +
+		`{"value":"first","stacktrace":{"frames":[{"filename":"app.js"},{"filename":"chrome-extension://abc/c.js"}]}},` +
+		`{"value":"second"}]},"request":{"url":"http://localhost:3000/"}}`)
+
+	event := getSentryFilterEvent(payload, RequestInfo{UserAgent: "Googlebot/2.1", Origin: "https://example.com"})
+	assert.Equal(t, []string{"first", "second", "boom"}, event.Messages)
+	assert.Equal(t, []string{"app.js", "chrome-extension://abc/c.js"}, event.Files)
+	assert.Equal(t, "1.2.0", event.Release)
+	assert.Equal(t, "Googlebot/2.1", event.UserAgent)
+	assert.Equal(t, "http://localhost:3000/", event.URL)
+}
+
+func TestGetFilterEvent(t *testing.T) {
+	payload := []byte(`{"title":"TypeError","backtrace":[{"file":"app.js"}],"addons":{"userAgent":"Mozilla/5.0 (compatible; MSIE 9.0)"}}`)
+
+	event := getFilterEvent(payload, RequestInfo{UserAgent: "node-fetch", Origin: "https://example.com"})
+	assert.Equal(t, []string{"TypeError"}, event.Messages)
+	assert.Equal(t, []string{"app.js"}, event.Files)
+	assert.Equal(t, "Mozilla/5.0 (compatible; MSIE 9.0)", event.UserAgent)
+	assert.Equal(t, "https://example.com", event.URL)
+}
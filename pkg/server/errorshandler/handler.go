@@ -3,12 +3,21 @@ package errorshandler
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/codex-team/hawk.collector/pkg/accounts"
 
 	"github.com/codex-team/hawk.collector/pkg/broker"
+	"github.com/codex-team/hawk.collector/pkg/dedup"
+	"github.com/codex-team/hawk.collector/pkg/enrichment"
+	"github.com/codex-team/hawk.collector/pkg/iplimit"
+	"github.com/codex-team/hawk.collector/pkg/ratelimit"
 	"github.com/codex-team/hawk.collector/pkg/redis"
+	"github.com/codex-team/hawk.collector/pkg/schemas"
+	"github.com/codex-team/hawk.collector/pkg/spikeprotection"
+	"github.com/codex-team/hawk.collector/pkg/timestamps"
+	"github.com/codex-team/hawk.collector/pkg/tsmetrics"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
@@ -24,86 +33,145 @@ type Handler struct {
 	// Maximum POST body size in bytes for error messages
 	MaxErrorCatcherMessageSize int
 
+	// TruncateOversized enables trimming of messages up to MaxTruncatedMessageSize to fit into MaxErrorCatcherMessageSize
+	TruncateOversized       bool
+	MaxTruncatedMessageSize int
+
 	ErrorsBlockedByLimit           prometheus.Counter
 	ErrorsProcessed                prometheus.Counter
 	ErrorsRejectedMessageTooLarge  prometheus.Counter
+	ErrorsTruncated                prometheus.Counter
 
 	RedisClient           *redis.RedisClient
 	AccountsMongoDBClient *accounts.AccountsMongoDBClient
+	RateLimiter           *ratelimit.Limiter
+	SpikeProtection       *spikeprotection.Protector
+
+	// Metrics accumulates project and workspace time series written to Redis periodically
+	Metrics *tsmetrics.Aggregator
+
+	// Deduplicator suppresses duplicates of events of projects which opted in, nil disables deduplication
+	Deduplicator *dedup.Deduplicator
+
+	// Timestamps detects client clock skew and buffered events
+	Timestamps timestamps.Normalizer
+
+	// Enricher attaches the client context to events, nil disables enrichment
+	Enricher *enrichment.Enricher
+
+	// IPLimiter counts requests with invalid tokens per client IP, nil disables the limit
+	IPLimiter *iplimit.Limiter
+
+	// Schemas validate payloads per catcher type, nil disables validation
+	Schemas *schemas.Registry
+
+	// FingerprintPaths overrides gjson paths used to fingerprint events per catcher type
+	FingerprintPaths map[string][]string
 
 	NonDefaultQueues map[string]bool
 }
 
-func (handler *Handler) process(body []byte) ResponseMessage {
+func (handler *Handler) process(body []byte, request RequestInfo) ResponseMessage {
 	// Check if the body is a valid JSON with the Message structure
 	message := CatcherMessage{}
 	err := json.Unmarshal(body, &message)
 	if err != nil {
-		return ResponseMessage{400, true, "Invalid JSON format"}
+		return ResponseMessage{Code: 400, Error: true, Message: "Invalid JSON format"}
 	}
 
 	if len(message.Payload) == 0 {
-		return ResponseMessage{400, true, "Payload is empty"}
+		return ResponseMessage{Code: 400, Error: true, Message: "Payload is empty"}
 	}
 	if message.Token == "" {
-		return ResponseMessage{400, true, "Token is empty"}
+		handler.IPLimiter.Invalid(request.IP)
+		return ResponseMessage{Code: 400, Error: true, Message: "Token is empty"}
 	}
 	if message.CatcherType == "" {
-		return ResponseMessage{400, true, "CatcherType is empty"}
+		return ResponseMessage{Code: 400, Error: true, Message: "CatcherType is empty"}
 	}
 
 	integrationSecret, err := accounts.DecodeToken(string(message.Token))
 	if err != nil {
 		log.Warnf("[release] Token decoding error: %s", err)
-		return ResponseMessage{400, true, "Token decoding error"}
+		handler.IPLimiter.Invalid(request.IP)
+		return ResponseMessage{Code: 400, Error: true, Message: "Token decoding error"}
 	}
 
 	projectId, ok := handler.AccountsMongoDBClient.GetValidToken(integrationSecret)
 	if !ok {
 		log.Debugf("Token %s is not in the accounts cache", integrationSecret)
-		return ResponseMessage{400, true, fmt.Sprintf("Integration token invalid: %s", integrationSecret)}
+		handler.IPLimiter.Invalid(request.IP)
+		return ResponseMessage{Code: 400, Error: true, Message: fmt.Sprintf("Integration token invalid: %s", integrationSecret)}
 	}
 	log.Debugf("Found project with ID %s for integration token %s", projectId, integrationSecret)
 
-	projectLimits, ok := handler.AccountsMongoDBClient.GetProjectLimits(projectId)
-	if !ok {
-		log.Warnf("Project %s is not in the projects limits cache", projectId)
-	} else {
-		log.Debugf("Project %s limits: %+v", projectId, projectLimits)
+	if violations, ok := handler.validateSchema(projectId, message.CatcherType, message.Payload); !ok {
+		return ResponseMessage{Code: 400, Error: true, Message: fmt.Sprintf("Payload does not match %s schema: %s", message.CatcherType, strings.Join(violations, "; "))}
 	}
 
-	if handler.RedisClient.IsBlocked(projectId) {
-		handler.ErrorsBlockedByLimit.Inc()
-		handler.recordProjectMetrics(projectId, "events-rate-limited", false)
-		return ResponseMessage{402, true, "Project has exceeded the events limit"}
+	// Validate if message is a valid JSON
+	stringPayload := string(message.Payload)
+	if !gjson.Valid(stringPayload) {
+		handler.recordProjectMetrics(projectId, "events-invalid-payload", false)
+		return ResponseMessage{Code: 400, Error: true, Message: "Invalid payload JSON format"}
+	}
+
+	payload := handler.scrubPayload(projectId, []byte(stringPayload))
+
+	// oversized messages are accepted only if truncation is enabled,
+	// they are rejected before rate limits so they don't use up the quota
+	if len(body) > handler.MaxErrorCatcherMessageSize {
+		payload, ok = handler.truncatePayload(projectId, payload, handler.MaxErrorCatcherMessageSize-(len(body)-len(message.Payload)))
+		if !ok {
+			return ResponseMessage{Code: 400, Error: true, Message: "Request is too large"}
+		}
 	}
 
-	rateWithinLimit, err := handler.RedisClient.UpdateRateLimit(projectId, projectLimits.EventsLimit, projectLimits.EventsPeriod)
+	timing, err := handler.normalizeTiming(time.Now(), gjson.GetBytes(message.Payload, "timestamp"), gjson.Result{})
 	if err != nil {
-		log.Errorf("Failed to update rate limit: %s", err)
-		return ResponseMessage{402, true, "Failed to update rate limit"}
+		handler.recordProjectMetrics(projectId, "events-invalid-payload", false)
+		return ResponseMessage{Code: 400, Error: true, Message: "Event timestamp is too far in the future"}
 	}
-	if !rateWithinLimit {
-		handler.recordProjectMetrics(projectId, "events-rate-limited", false)
-		return ResponseMessage{402, true, "Rate limit exceeded"}
+
+	// filtered and sampled out events are not counted by rate limits
+	if !handler.applyFilters(projectId, message.Payload, request, getFilterEvent) {
+		return ResponseMessage{Code: 200, Error: false, Message: "OK"}
+	}
+	if !handler.applySampling(projectId, message.CatcherType, message.Payload) {
+		return ResponseMessage{Code: 200, Error: false, Message: "OK"}
 	}
 
-	// Validate if message is a valid JSON
-	stringPayload := string(message.Payload)
-	if !gjson.Valid(stringPayload) {
-		return ResponseMessage{400, true, "Invalid payload JSON format"}
+	response, ok := handler.applyRateLimits(projectId, message.CatcherType)
+	if !ok {
+		return response
 	}
 
 	// convert message to JSON format
-	messageToSend := BrokerMessage{Timestamp: time.Now().Unix(), ProjectId: projectId, Payload: []byte(stringPayload), CatcherType: message.CatcherType}
+	messageToSend := BrokerMessage{
+		Timestamp:        timing.ReceivedAt / 1000,
+		OccurredAt:       timing.OccurredAt,
+		ReceivedAt:       timing.ReceivedAt,
+		ClockSkew:        timing.Skew,
+		Buffered:         timing.Buffered,
+		ProjectId:        projectId,
+		Payload:          payload,
+		CatcherType:      message.CatcherType,
+		CollectorContext: handler.Enricher.Context(request.IP, request.UserAgent, handler.AccountsMongoDBClient.IsIPStored(projectId)),
+	}
+	if handler.deduplicate(&messageToSend, payload) {
+		return response
+	}
+
 	rawMessage, err := json.Marshal(messageToSend)
 	if err != nil {
 		log.Errorf("Message marshalling error: %v", err)
-		return ResponseMessage{400, true, "Cannot encode message to JSON"}
+		return ResponseMessage{Code: 400, Error: true, Message: "Cannot encode message to JSON"}
 	}
 
+	route := handler.determineQueue(message.CatcherType)
+
 	// send serialized message to a broker
-	brokerMessage := broker.Message{Payload: rawMessage, Route: handler.determineQueue(message.CatcherType)}
+	brokerMessage := broker.Message{Payload: rawMessage, Route: route}
 	log.Debugf("Send to queue: %s", brokerMessage)
 	handler.Broker.Chan <- brokerMessage
 
@@ -112,8 +180,103 @@ func (handler *Handler) process(body []byte) ResponseMessage {
 
 	// record project metrics
 	handler.recordProjectMetrics(projectId, "events-accepted", true)
+	handler.recordProjectValue(projectId, "bytes-accepted", false, int64(len(rawMessage)))
+	handler.recordWorkspaceMetrics(projectId, "events-accepted")
 
-	return ResponseMessage{200, false, "OK"}
+	return response
+}
+
+// Levels of rate limits hierarchy passed to the rate limiter
+const (
+	projectRateLimitLevel = iota
+	workspaceRateLimitLevel
+	spikeRateLimitLevel
+	rateLimitLevels
+)
+
+// applyRateLimits checks that the project is not blocked and counts the event by the project limit
+// and by the quota shared by all projects of the workspace.
+// Categories (catcher types or Sentry item categories) with own limits are counted separately from the project limit,
+// so a noisy category cannot starve the others.
+// Returns false and the response for a client if the event should be rejected,
+// otherwise the successful response with the rate limit status.
+func (handler *Handler) applyRateLimits(projectId, category string) (ResponseMessage, bool) {
+	projectLimits, ok := handler.AccountsMongoDBClient.GetProjectLimits(projectId)
+	if !ok {
+		log.Warnf("Project %s is not in the projects limits cache", projectId)
+	} else {
+		log.Debugf("Project %s limits: %+v", projectId, projectLimits)
+	}
+
+	counterId := projectId
+	if categoryLimits, ok := projectLimits.ForCategory(category); ok {
+		log.Debugf("Project %s limits for %s: %+v", projectId, category, categoryLimits)
+		projectLimits = categoryLimits
+		counterId = projectId + ":" + category
+	}
+
+	if handler.RedisClient.IsBlocked(projectId) {
+		handler.ErrorsBlockedByLimit.Inc()
+		handler.recordProjectMetrics(projectId, "events-rate-limited", false)
+		handler.recordProjectMetrics(projectId, "events-blocked", false)
+		return ResponseMessage{Code: 402, Error: true, Message: "Project has exceeded the events limit"}, false
+	}
+
+	// Levels without limits are skipped by the rate limiter.
+	// All levels share the workspace group, so the workspace quota is kept together with its projects counters.
+	limits := make([]redis.RateLimit, rateLimitLevels)
+	group := projectId
+	workspaceId, workspaceLimits, ok := handler.AccountsMongoDBClient.GetWorkspaceLimits(projectId)
+	if ok {
+		log.Debugf("Workspace %s limits: %+v", workspaceId, workspaceLimits)
+		group = "workspace:" + workspaceId
+		limits[workspaceRateLimitLevel] = redis.RateLimit{
+			ID:        "workspace:" + workspaceId,
+			Limit:     workspaceLimits.EventsLimit,
+			Period:    workspaceLimits.EventsPeriod,
+			Algorithm: workspaceLimits.Algorithm,
+			Group:     group,
+		}
+	}
+
+	limits[projectRateLimitLevel] = redis.RateLimit{
+		ID:        counterId,
+		Limit:     projectLimits.EventsLimit,
+		Period:    projectLimits.EventsPeriod,
+		Algorithm: projectLimits.Algorithm,
+		Group:     group,
+	}
+
+	limits[spikeRateLimitLevel] = handler.SpikeProtection.Limit(projectId)
+	limits[spikeRateLimitLevel].Group = group
+
+	result, err := handler.RateLimiter.Allow(counterId, limits...)
+	if err != nil {
+		log.Errorf("Failed to update rate limit: %s", err)
+		return ResponseMessage{Code: 402, Error: true, Message: "Failed to update rate limit"}, false
+	}
+
+	var status *RateLimitStatus
+	if result.Limit > 0 {
+		status = &RateLimitStatus{Limit: result.Limit, Remaining: result.Remaining, Reset: result.Reset}
+	}
+
+	switch result.Exceeded {
+	case projectRateLimitLevel:
+		handler.recordProjectMetrics(projectId, "events-rate-limited", false)
+		return ResponseMessage{Code: 402, Error: true, Message: "Rate limit exceeded", RateLimit: status}, false
+	case workspaceRateLimitLevel:
+		log.Debugf("Workspace %s has exceeded the quota", workspaceId)
+		handler.recordProjectMetrics(projectId, "events-rate-limited", false)
+		handler.recordWorkspaceMetrics(projectId, "events-rate-limited")
+		return ResponseMessage{Code: 402, Error: true, Message: "Workspace rate limit exceeded", RateLimit: status}, false
+	case spikeRateLimitLevel:
+		handler.recordProjectMetrics(projectId, "events-spike-protected", false)
+		handler.SpikeProtection.Alert(projectId)
+		return ResponseMessage{Code: 402, Error: true, Message: "Spike protection limit exceeded", RateLimit: status}, false
+	}
+
+	return ResponseMessage{Code: 200, Error: false, Message: "OK", RateLimit: status}, true
 }
 
 // determineQueue - determine RabbitMQ route from catcherType
@@ -133,42 +296,18 @@ func GetQueueCache(nonDefaultQueues []string) map[string]bool {
 	return cache
 }
 
-// getTimeSeriesKey generates a Redis TimeSeries key for project metrics
-func getTimeSeriesKey(projectId, metricType, granularity string, isSystemMetric bool) string {
-	// flag determines which counter would be incremented
-	if isSystemMetric {
-		// ts:collector-project-%s:%s:%s could be used in admin
-		return fmt.Sprintf("ts:collector-project-%s:%s:%s", metricType, projectId, granularity)
-	}
-
-	// ts:project-%s:%s:%s is used in api for chart retrieving
-	return fmt.Sprintf("ts:project-%s:%s:%s", metricType, projectId, granularity)
-}
-
-// bucketTimestampMs returns the current UTC time truncated to the start of the
-// given granularity bucket, in milliseconds. Truncating ensures that all events
-// within the same bucket share one timestamp so ON_DUPLICATE SUM accumulates
-// them into a single sample instead of creating a separate sample per event.
-func bucketTimestampMs(granularity string) int64 {
-	now := time.Now().UTC()
-	var t time.Time
-	switch granularity {
-	case "hourly":
-		t = now.Truncate(time.Hour)
-	case "daily":
-		t = now.Truncate(24 * time.Hour)
-	default: // minutely
-		t = now.Truncate(time.Minute)
-	}
-	return t.UnixNano() / int64(time.Millisecond)
-}
-
 // recordProjectMetrics records project metrics to Redis TimeSeries
 // metricType can be: "events-accepted", "events-rate-limited", etc.
 func (handler *Handler) recordProjectMetrics(projectId, metricType string, isSystemMetric bool) {
-	minutelyKey := getTimeSeriesKey(projectId, metricType, "minutely", isSystemMetric)
-	hourlyKey := getTimeSeriesKey(projectId, metricType, "hourly", isSystemMetric)
-	dailyKey := getTimeSeriesKey(projectId, metricType, "daily", isSystemMetric)
+	handler.recordProjectValue(projectId, metricType, isSystemMetric, 1)
+}
+
+// recordProjectValue adds value to project metrics in Redis TimeSeries,
+// e.g. "bytes-accepted" is the size of broker messages of accepted events
+func (handler *Handler) recordProjectValue(projectId, metricType string, isSystemMetric bool, value int64) {
+	minutelyKey := tsmetrics.ProjectKey(projectId, metricType, "minutely", isSystemMetric)
+	hourlyKey := tsmetrics.ProjectKey(projectId, metricType, "hourly", isSystemMetric)
+	dailyKey := tsmetrics.ProjectKey(projectId, metricType, "daily", isSystemMetric)
 
 	labels := map[string]string{
 		"type":    "error",
@@ -176,18 +315,50 @@ func (handler *Handler) recordProjectMetrics(projectId, metricType string, isSys
 		"project": projectId,
 	}
 
-	// minutely: store for 24 hours
-	if err := handler.RedisClient.SafeTSAdd(minutelyKey, 1, labels, 24*time.Hour, bucketTimestampMs("minutely")); err != nil {
-		log.Errorf("failed to add minutely TS for %s: %v", metricType, err)
+	handler.recordTimeSeries(minutelyKey, hourlyKey, dailyKey, labels, value)
+}
+
+// recordWorkspaceMetrics records metrics of the project workspace to Redis TimeSeries
+// Does nothing if the project workspace is unknown
+func (handler *Handler) recordWorkspaceMetrics(projectId, metricType string) {
+	workspaceId, _, ok := handler.AccountsMongoDBClient.GetWorkspaceLimits(projectId)
+	if !ok {
+		return
+	}
+
+	minutelyKey := tsmetrics.WorkspaceKey(workspaceId, metricType, "minutely")
+	hourlyKey := tsmetrics.WorkspaceKey(workspaceId, metricType, "hourly")
+	dailyKey := tsmetrics.WorkspaceKey(workspaceId, metricType, "daily")
+
+	labels := map[string]string{
+		"type":      "error",
+		"status":    metricType,
+		"workspace": workspaceId,
 	}
 
-	// hourly: store for 7 days
-	if err := handler.RedisClient.SafeTSAdd(hourlyKey, 1, labels, 7*24*time.Hour, bucketTimestampMs("hourly")); err != nil {
-		log.Errorf("failed to add hourly TS for %s: %v", metricType, err)
+	handler.recordTimeSeries(minutelyKey, hourlyKey, dailyKey, labels, 1)
+}
+
+// recordTimeSeries adds value to minutely, hourly and daily series
+func (handler *Handler) recordTimeSeries(minutelyKey, hourlyKey, dailyKey string, labels map[string]string, value int64) {
+	handler.Metrics.Count(minutelyKey, hourlyKey, dailyKey, labels, value)
+}
+
+// validateSchema validates the payload against the schema of the catcher type.
+// Violations are recorded as "events-schema-violations" metric and rejected payloads as "events-invalid-payload",
+// returns false with violations if the payload should be rejected.
+func (handler *Handler) validateSchema(projectId, catcherType string, payload []byte) ([]string, bool) {
+	violations := handler.Schemas.Validate(catcherType, payload)
+	if len(violations) == 0 {
+		return nil, true
 	}
 
-	// daily: store for 90 days
-	if err := handler.RedisClient.SafeTSAdd(dailyKey, 1, labels, 90*24*time.Hour, bucketTimestampMs("daily")); err != nil {
-		log.Errorf("failed to add daily TS for %s: %v", metricType, err)
+	log.Debugf("Payload of project %s does not match %s schema: %v", projectId, catcherType, violations)
+	handler.recordProjectMetrics(projectId, "events-schema-violations", false)
+	if handler.Schemas.Mode == schemas.WarnOnly {
+		return violations, true
 	}
+
+	handler.recordProjectMetrics(projectId, "events-invalid-payload", false)
+	return violations, false
 }
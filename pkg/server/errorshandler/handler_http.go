@@ -3,6 +3,7 @@ package errorshandler
 import (
 	"encoding/json"
 	"errors"
+	"strconv"
 
 	"github.com/codex-team/hawk.collector/pkg/hawk"
 	log "github.com/sirupsen/logrus"
@@ -11,14 +12,8 @@ import (
 
 // HandleHTTP processes HTTP requests with JSON body
 func (handler *Handler) HandleHTTP(ctx *fasthttp.RequestCtx) {
-	if ctx.Request.Header.ContentLength() > handler.MaxErrorCatcherMessageSize {
-		handler.ErrorsRejectedMessageTooLarge.Inc()
-		log.Warnf("Incoming request with size %d", ctx.Request.Header.ContentLength())
-		sendAnswerHTTP(ctx, ResponseMessage{
-			Code:    400,
-			Error:   true,
-			Message: "Request is too large",
-		})
+	if ctx.Request.Header.ContentLength() > handler.maxMessageSize() {
+		handler.rejectTooLarge(ctx, prefixIntegrationSecret(ctx.PostBody(), handler.maxMessageSize()))
 		return
 	}
 
@@ -26,7 +21,7 @@ func (handler *Handler) HandleHTTP(ctx *fasthttp.RequestCtx) {
 	body := ctx.PostBody()
 	log.Debugf("Headers: %s\nBody: %s", ctx.Request.Header.String(), body)
 
-	response := handler.process(body)
+	response := handler.process(body, getRequestInfo(ctx))
 	log.Debugf("Response: %s", response.Message)
 
 	sendAnswerHTTP(ctx, response)
@@ -39,6 +34,12 @@ func sendAnswerHTTP(ctx *fasthttp.RequestCtx, r ResponseMessage) {
 	}
 	ctx.Response.SetStatusCode(r.Code)
 
+	if r.RateLimit != nil {
+		ctx.Response.Header.Set("X-RateLimit-Limit", strconv.FormatInt(r.RateLimit.Limit, 10))
+		ctx.Response.Header.Set("X-RateLimit-Remaining", strconv.FormatInt(r.RateLimit.Remaining, 10))
+		ctx.Response.Header.Set("X-RateLimit-Reset", strconv.FormatInt(r.RateLimit.Reset, 10))
+	}
+
 	if r.Code != 200 {
 		hawk.Catch(errors.New(r.Message))
 	}
@@ -3,10 +3,12 @@ package errorshandler
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/codex-team/hawk.collector/pkg/broker"
 	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
 	"github.com/valyala/fasthttp"
 )
 
@@ -19,18 +21,12 @@ func allowCORS(ctx *fasthttp.RequestCtx) {
 	h.Set("Access-Control-Allow-Origin", "*")
 	h.Set("Access-Control-Allow-Methods", "POST, OPTIONS")
 	h.Set("Access-Control-Allow-Headers", "Content-Type, X-Sentry-Auth")
+	h.Set("Access-Control-Expose-Headers", "X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset")
 	h.Set("Access-Control-Max-Age", "86400")
 }
 
 // HandleHTTP processes HTTP requests with JSON body
 func (handler *Handler) HandleSentry(ctx *fasthttp.RequestCtx) {
-	if ctx.Request.Header.ContentLength() > handler.MaxErrorCatcherMessageSize {
-		handler.ErrorsRejectedMessageTooLarge.Inc()
-		log.Warnf("Incoming request with size %d", ctx.Request.Header.ContentLength())
-		sendAnswerHTTP(ctx, ResponseMessage{Code: 400, Error: true, Message: "Request is too large"})
-		return
-	}
-
 	allowCORS(ctx)
 	if string(ctx.Method()) == fasthttp.MethodOptions {
 		ctx.SetStatusCode(fasthttp.StatusNoContent) // 204
@@ -47,6 +43,7 @@ func (handler *Handler) HandleSentry(ctx *fasthttp.RequestCtx) {
 		auth := ctx.Request.Header.Peek("X-Sentry-Auth")
 		if auth == nil {
 			log.Warnf("Incoming request without X-Sentry-Auth header")
+			handler.IPLimiter.Invalid(getRequestInfo(ctx).IP)
 			sendAnswerHTTP(ctx, ResponseMessage{Code: 400, Error: true, Message: "X-Sentry-Auth header is missing"})
 			return
 		}
@@ -54,6 +51,7 @@ func (handler *Handler) HandleSentry(ctx *fasthttp.RequestCtx) {
 		hawkToken, err = getSentryKeyFromAuth(string(auth))
 		if err != nil {
 			log.Warnf("Incoming request with invalid X-Sentry-Auth header=%s: %s", auth, err)
+			handler.IPLimiter.Invalid(getRequestInfo(ctx).IP)
 			sendAnswerHTTP(ctx, ResponseMessage{Code: 400, Error: true, Message: err.Error()})
 			return
 		}
@@ -63,6 +61,12 @@ func (handler *Handler) HandleSentry(ctx *fasthttp.RequestCtx) {
 
 	log.Debugf("Incoming request with hawk integration token: %s", hawkToken)
 
+	// the token comes from the query or the header, so oversized envelopes are counted per project without reading the body
+	if ctx.Request.Header.ContentLength() > handler.MaxErrorCatcherMessageSize {
+		handler.rejectTooLarge(ctx, hawkToken)
+		return
+	}
+
 	sentryEnvelopeBody := ctx.PostBody()
 
 	contentEncoding := string(ctx.Request.Header.Peek("Content-Encoding"))
@@ -89,51 +93,75 @@ func (handler *Handler) HandleSentry(ctx *fasthttp.RequestCtx) {
 	projectId, ok := handler.AccountsMongoDBClient.GetValidToken(hawkToken)
 	if !ok {
 		log.Warnf("Token %s is not in the accounts cache", hawkToken)
-		sendAnswerHTTP(ctx, ResponseMessage{400, true, fmt.Sprintf("Integration token invalid: %s", hawkToken)})
+		handler.IPLimiter.Invalid(getRequestInfo(ctx).IP)
+		sendAnswerHTTP(ctx, ResponseMessage{Code: 400, Error: true, Message: fmt.Sprintf("Integration token invalid: %s", hawkToken)})
 		return
 	}
 	log.Debugf("Found project with ID %s for integration token %s", projectId, hawkToken)
 
-	projectLimits, ok := handler.AccountsMongoDBClient.GetProjectLimits(projectId)
-	if !ok {
-		log.Warnf("Project %s is not in the projects limits cache", projectId)
-	} else {
-		log.Debugf("Project %s limits: %+v", projectId, projectLimits)
+	// the schema describes error events, other items like transactions or sessions are not validated
+	if getSentryItemType(sentryEnvelopeBody) == "event" {
+		if violations, ok := handler.validateSchema(projectId, CatcherType, sentryEventPayload(sentryEnvelopeBody)); !ok {
+			sendAnswerHTTP(ctx, ResponseMessage{Code: 400, Error: true, Message: fmt.Sprintf("Event does not match %s schema: %s", CatcherType, strings.Join(violations, "; "))})
+			return
+		}
 	}
 
-	if handler.RedisClient.IsBlocked(projectId) {
-		handler.ErrorsBlockedByLimit.Inc()
-		handler.recordProjectMetrics(projectId, "events-rate-limited", false)
-		sendAnswerHTTP(ctx, ResponseMessage{402, true, "Project has exceeded the events limit"})
+	// event timestamp is corrected by the clock skew measured with the envelope send time
+	timing, err := handler.normalizeTiming(time.Now(), gjson.GetBytes(sentryEventPayload(sentryEnvelopeBody), "timestamp"), gjson.GetBytes(sentryEnvelopeBody, "sent_at"))
+	if err != nil {
+		handler.recordProjectMetrics(projectId, "events-invalid-payload", false)
+		sendAnswerHTTP(ctx, ResponseMessage{Code: 400, Error: true, Message: "Event timestamp is too far in the future"})
 		return
 	}
 
-	rateWithinLimit, err := handler.RedisClient.UpdateRateLimit(projectId, projectLimits.EventsLimit, projectLimits.EventsPeriod)
-	if err != nil {
-		log.Errorf("Failed to update rate limit: %s", err)
-		sendAnswerHTTP(ctx, ResponseMessage{402, true, "Failed to update rate limit"})
+	// filtered and sampled out events are not counted by rate limits
+	if !handler.applyFilters(projectId, sentryEventPayload(sentryEnvelopeBody), getRequestInfo(ctx), getSentryFilterEvent) {
+		sendAnswerHTTP(ctx, ResponseMessage{Code: 200, Error: false, Message: "OK"})
+		return
+	}
+	if !handler.applySampling(projectId, CatcherType, sentryEventPayload(sentryEnvelopeBody)) {
+		sendAnswerHTTP(ctx, ResponseMessage{Code: 200, Error: false, Message: "OK"})
 		return
 	}
 
-	if !rateWithinLimit {
-		handler.recordProjectMetrics(projectId, "events-rate-limited", false)
-		sendAnswerHTTP(ctx, ResponseMessage{402, true, "Rate limit exceeded"})
+	response, ok := handler.applyRateLimits(projectId, getSentryCategory(sentryEnvelopeBody))
+	if !ok {
+		sendAnswerHTTP(ctx, response)
 		return
 	}
 
+	sentryEnvelopeBody = handler.scrubEnvelope(projectId, sentryEnvelopeBody)
+
 	// convert message to JSON format
 	rawMessage := RawSentryMessage{Envelope: sentryEnvelopeBody}
 	jsonMessage, err := json.Marshal(rawMessage)
 	if err != nil {
 		log.Errorf("Message marshalling error: %v", err)
-		sendAnswerHTTP(ctx, ResponseMessage{400, true, "Cannot serialize envelope"})
+		sendAnswerHTTP(ctx, ResponseMessage{Code: 400, Error: true, Message: "Cannot serialize envelope"})
+	}
+
+	request := getRequestInfo(ctx)
+	messageToSend := BrokerMessage{
+		Timestamp:        timing.ReceivedAt / 1000,
+		OccurredAt:       timing.OccurredAt,
+		ReceivedAt:       timing.ReceivedAt,
+		ClockSkew:        timing.Skew,
+		Buffered:         timing.Buffered,
+		ProjectId:        projectId,
+		Payload:          json.RawMessage(jsonMessage),
+		CatcherType:      CatcherType,
+		CollectorContext: handler.Enricher.Context(request.IP, request.UserAgent, handler.AccountsMongoDBClient.IsIPStored(projectId)),
+	}
+	if handler.deduplicate(&messageToSend, sentryEventPayload(sentryEnvelopeBody)) {
+		sendAnswerHTTP(ctx, response)
+		return
 	}
 
-	messageToSend := BrokerMessage{Timestamp: time.Now().Unix(), ProjectId: projectId, Payload: json.RawMessage(jsonMessage), CatcherType: CatcherType}
 	payloadToSend, err := json.Marshal(messageToSend)
 	if err != nil {
 		log.Errorf("Message marshalling error: %v", err)
-		sendAnswerHTTP(ctx, ResponseMessage{400, true, "Cannot serialize envelope"})
+		sendAnswerHTTP(ctx, ResponseMessage{Code: 400, Error: true, Message: "Cannot serialize envelope"})
 	}
 
 	// send serialized message to a broker
@@ -146,6 +174,8 @@ func (handler *Handler) HandleSentry(ctx *fasthttp.RequestCtx) {
 
 	// record project metrics
 	handler.recordProjectMetrics(projectId, "events-accepted", true)
+	handler.recordProjectValue(projectId, "bytes-accepted", false, int64(len(payloadToSend)))
+	handler.recordWorkspaceMetrics(projectId, "events-accepted")
 
-	sendAnswerHTTP(ctx, ResponseMessage{200, false, "OK"})
+	sendAnswerHTTP(ctx, response)
 }
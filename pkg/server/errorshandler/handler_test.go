@@ -0,0 +1,99 @@
+package errorshandler
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/codex-team/hawk.collector/pkg/accounts"
+	"github.com/codex-team/hawk.collector/pkg/tsmetrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+// newTooLargeTestHandler returns handler accepting messages up to 100 bytes with token of project p1
+func newTooLargeTestHandler() *Handler {
+	return &Handler{
+		MaxErrorCatcherMessageSize:    100,
+		ErrorsRejectedMessageTooLarge: prometheus.NewCounter(prometheus.CounterOpts{Name: "test_rejected_too_large"}),
+		AccountsMongoDBClient:         accounts.NewWithTokens(map[string]string{"i1s1": "p1"}),
+		Metrics:                       tsmetrics.New(nil, tsmetrics.Retention{}, false),
+	}
+}
+
+// newTestRequest returns POST request context with the body and its Content-Length
+func newTestRequest(uri string, body []byte) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.SetRequestURI(uri)
+	ctx.Request.SetBody(body)
+	ctx.Request.Header.SetContentLength(len(body))
+	return ctx
+}
+
+func TestHandleHTTPTooLarge(t *testing.T) {
+	token := base64.StdEncoding.EncodeToString([]byte(`{"integrationId":"i1","secret":"s1"}`))
+	payload := `{"title":"` + strings.Repeat("a", 200) + `"}`
+
+	tests := []struct {
+		name string
+		body string
+		want map[string]int64
+	}{
+		{
+			name: "token in the prefix",
+			body: `{"token":"` + token + `","catcherType":"errors/javascript","payload":` + payload + `}`,
+			want: map[string]int64{
+				"ts:project-events-too-large:p1:minutely": 1,
+				"ts:project-events-too-large:p1:hourly":   1,
+				"ts:project-events-too-large:p1:daily":    1,
+			},
+		},
+		{
+			name: "token after the prefix",
+			body: `{"catcherType":"errors/javascript","payload":` + payload + `,"token":"` + token + `"}`,
+			want: map[string]int64{},
+		},
+		{
+			name: "unknown token",
+			body: `{"token":"` + base64.StdEncoding.EncodeToString([]byte(`{"integrationId":"i2","secret":"s2"}`)) + `","payload":` + payload + `}`,
+			want: map[string]int64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newTooLargeTestHandler()
+			ctx := newTestRequest("/", []byte(tt.body))
+
+			handler.HandleHTTP(ctx)
+			assert.Equal(t, 400, ctx.Response.StatusCode())
+			assert.Equal(t, tt.want, handler.Metrics.Pending())
+		})
+	}
+}
+
+func TestHandleSentryTooLarge(t *testing.T) {
+	body := []byte(strings.Repeat("a", 200))
+	want := map[string]int64{
+		"ts:project-events-too-large:p1:minutely": 1,
+		"ts:project-events-too-large:p1:hourly":   1,
+		"ts:project-events-too-large:p1:daily":    1,
+	}
+
+	// the key is taken from the query
+	handler := newTooLargeTestHandler()
+	ctx := newTestRequest("/api/0/envelope/?sentry_key=i1s1", body)
+	handler.HandleSentry(ctx)
+	assert.Equal(t, 400, ctx.Response.StatusCode())
+	assert.Equal(t, want, handler.Metrics.Pending())
+
+	// the key is taken from the X-Sentry-Auth header
+	handler = newTooLargeTestHandler()
+	ctx = newTestRequest("/api/0/envelope/", body)
+	ctx.Request.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_key=i1s1, sentry_client=test/1.0")
+	handler.HandleSentry(ctx)
+	assert.Equal(t, 400, ctx.Response.StatusCode())
+	assert.Equal(t, want, handler.Metrics.Pending())
+}
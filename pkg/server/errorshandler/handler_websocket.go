@@ -65,14 +65,17 @@ func (handler *Handler) HandleWebsocket(ctx *fasthttp.RequestCtx) {
 	// Increment connection counter
 	collectorWebsocketConnectionsTotal.Inc()
 
+	// headers of the upgrade request are used by inbound filters for all messages of the connection
+	request := getRequestInfo(ctx)
+
 	err := upgrader.Upgrade(ctx, func(conn *websocket.Conn) {
 		// Increment active connections gauge
 		collectorWebsocketActiveConnections.Inc()
 		// Ensure we decrement the gauge when connection ends
 		defer collectorWebsocketActiveConnections.Dec()
 
-		// limit read size of MaxErrorCatcherMessageSize bytes
-		conn.SetReadLimit(int64(handler.MaxErrorCatcherMessageSize))
+		// limit read size of MaxErrorCatcherMessageSize bytes or MaxTruncatedMessageSize if truncation is enabled
+		conn.SetReadLimit(int64(handler.maxMessageSize()))
 
 		for {
 			messageType, message, err := conn.ReadMessage()
@@ -91,7 +94,7 @@ func (handler *Handler) HandleWebsocket(ctx *fasthttp.RequestCtx) {
 			log.Debugf("Websocket message: %s", message)
 
 			// process raw body via unified message handler
-			response := handler.process(message)
+			response := handler.process(message, request)
 			log.Debugf("Websocket response: %s", response.Message)
 
 			if err = sendAnswerWebsocket(conn, messageType, response); err != nil {
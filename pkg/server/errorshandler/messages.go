@@ -2,6 +2,8 @@ package errorshandler
 
 import (
 	"encoding/json"
+
+	"github.com/codex-team/hawk.collector/pkg/enrichment"
 )
 
 // ResponseMessage represents incoming message from a client
@@ -16,6 +18,21 @@ type ResponseMessage struct {
 	Code    int    `json:"code"`
 	Error   bool   `json:"error"`
 	Message string `json:"message"`
+
+	// RateLimit is the status of the most restrictive project rate limit, omitted if the project is not limited
+	RateLimit *RateLimitStatus `json:"rateLimit,omitempty"`
+}
+
+// RateLimitStatus lets catchers self-throttle before hitting the limit
+type RateLimitStatus struct {
+	// Maximum number of events in the window
+	Limit int64 `json:"limit"`
+
+	// Number of events left in the window
+	Remaining int64 `json:"remaining"`
+
+	// Number of seconds until the window is reset
+	Reset int64 `json:"reset"`
 }
 
 // BrokerMessage represents message to a queue
@@ -24,6 +41,44 @@ type BrokerMessage struct {
 	Payload     json.RawMessage `json:"payload"`
 	CatcherType string          `json:"catcherType"`
 	Timestamp int64             `json:"timestamp"`
+
+	// OccurredAt is the client time of the event corrected by the clock skew in milliseconds
+	OccurredAt int64 `json:"occurredAt"`
+
+	// ReceivedAt is the time the collector received the event in milliseconds
+	ReceivedAt int64 `json:"receivedAt"`
+
+	// ClockSkew is the difference between the collector and the client clocks in milliseconds, if measurable
+	ClockSkew int64 `json:"clockSkew,omitempty"`
+
+	// Buffered is set if the event was sent long after it occurred, e.g. kept by the client offline
+	Buffered bool `json:"buffered,omitempty"`
+
+	// CollectorContext is the data about the client known to the collector
+	CollectorContext *enrichment.Context `json:"collectorContext,omitempty"`
+
+	// Fingerprint identifies the first event of the deduplication window in RepeatsMessage,
+	// set only for projects with deduplication
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// RepeatsMessage represents the number of duplicates of the event suppressed by the collector,
+// sent to RepeatsQueueName when the deduplication window ends
+type RepeatsMessage struct {
+	ProjectId   string `json:"projectId"`
+	CatcherType string `json:"catcherType"`
+
+	// Fingerprint is the fingerprint of the first event sent to the broker
+	Fingerprint string `json:"fingerprint"`
+
+	// RepeatCount is the number of duplicates suppressed since the first event
+	RepeatCount int64 `json:"repeatCount"`
+
+	// FirstReceivedAt is the time the first event was received in milliseconds
+	FirstReceivedAt int64 `json:"firstReceivedAt"`
+
+	// LastReceivedAt is the time the last duplicate was received in milliseconds
+	LastReceivedAt int64 `json:"lastReceivedAt"`
 }
 
 type RawSentryMessage struct {
@@ -0,0 +1,92 @@
+package errorshandler
+
+import (
+	"bytes"
+	"hash/fnv"
+	"math/rand"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+// samplingPrecision is the number of buckets fingerprints are distributed between
+const samplingPrecision = 1000000
+
+// defaultFingerprintPaths are gjson paths of Hawk catchers payload fields which identify the error
+var defaultFingerprintPaths = []string{"title", "type", "backtrace.#.file", "backtrace.#.line"}
+
+// sentryFingerprintPaths are gjson paths of Sentry event fields which identify the error
+var sentryFingerprintPaths = []string{
+	"exception.values.#.type",
+	"exception.values.#.value",
+	"exception.values.#.stacktrace.frames.#.filename",
+	"exception.values.#.stacktrace.frames.#.lineno",
+	"message",
+	"logentry.message",
+}
+
+// payloadFingerprint returns values of the paths joined together,
+// so duplicates of the same error get the same fingerprint regardless of timestamps and context.
+// Returns nil if none of the paths exist, e.g. for Sentry transactions, since such events can't be told apart.
+func payloadFingerprint(payload []byte, paths []string) []byte {
+	var fingerprint bytes.Buffer
+	found := false
+	for _, result := range gjson.GetManyBytes(payload, paths...) {
+		found = found || result.Exists()
+		fingerprint.WriteString(result.Raw)
+		fingerprint.WriteByte(0)
+	}
+	if !found {
+		return nil
+	}
+	return fingerprint.Bytes()
+}
+
+// sentryEventPayload returns the payload of the first envelope item.
+// Envelope starts with a header line followed by the first item header line and the item payload.
+func sentryEventPayload(envelope []byte) []byte {
+	lines := bytes.SplitN(envelope, []byte("\n"), 4)
+	if len(lines) < 3 {
+		return nil
+	}
+	return lines[2]
+}
+
+// keepSample decides whether the event is kept with the probability of rate.
+// If fingerprint is provided, the decision is deterministic: all events with the same fingerprint are kept or dropped together.
+func keepSample(rate float64, fingerprint []byte) bool {
+	if rate >= 1 {
+		return true
+	}
+
+	if fingerprint == nil {
+		return rand.Float64() < rate
+	}
+
+	hash := fnv.New64a()
+	_, _ = hash.Write(fingerprint)
+	return float64(hash.Sum64()%samplingPrecision) < rate*samplingPrecision
+}
+
+// applySampling decides whether the event of the project should be kept according to the project sampling settings.
+// payload is used to compute the fingerprint if deterministic sampling is enabled.
+// Sampled out events are recorded as "events-sampled-out" metric.
+func (handler *Handler) applySampling(projectId, catcherType string, payload []byte) bool {
+	sampling, ok := handler.AccountsMongoDBClient.GetProjectSampling(projectId)
+	if !ok {
+		return true
+	}
+
+	var fingerprint []byte
+	if sampling.Fingerprint {
+		fingerprint = payloadFingerprint(payload, handler.fingerprintPaths(catcherType))
+	}
+
+	if keepSample(sampling.RateFor(catcherType), fingerprint) {
+		return true
+	}
+
+	log.Debugf("Event of project %s is sampled out", projectId)
+	handler.recordProjectMetrics(projectId, "events-sampled-out", false)
+	return false
+}
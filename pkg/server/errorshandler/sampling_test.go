@@ -0,0 +1,52 @@
+package errorshandler
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestKeepSampleFingerprint(t *testing.T) {
+	first := payloadFingerprint([]byte(`{"title":"TypeError","timestamp":1,"backtrace":[{"file":"app.js","line":10}]}`), defaultFingerprintPaths)
+	second := payloadFingerprint([]byte(`{"title":"TypeError","timestamp":2,"backtrace":[{"file":"app.js","line":10}]}`), defaultFingerprintPaths)
+	if string(first) != string(second) {
+		t.Errorf("payloadFingerprint() differs for duplicates: %q != %q", first, second)
+	}
+
+	for i := 0; i < 10; i++ {
+		if keepSample(0.5, first) != keepSample(0.5, second) {
+			t.Fatalf("keepSample() is not deterministic for the same fingerprint")
+		}
+	}
+
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		if keepSample(0.25, []byte(fmt.Sprintf("error %d", i))) {
+			kept++
+		}
+	}
+	if kept < 2000 || kept > 3000 {
+		t.Errorf("keepSample(0.25) kept %d of 10000 fingerprints", kept)
+	}
+
+	if !keepSample(1, nil) || keepSample(0, first) {
+		t.Errorf("keepSample() ignores rate bounds")
+	}
+}
+
+func TestPayloadFingerprintWithoutPaths(t *testing.T) {
+	// Sentry transactions have none of the error fields, so they must be sampled randomly
+	transaction := []byte(`{"type":"transaction","transaction":"/api","spans":[]}`)
+	if fingerprint := payloadFingerprint(transaction, sentryFingerprintPaths); fingerprint != nil {
+		t.Errorf("payloadFingerprint() = %q, want nil if no path exists", fingerprint)
+	}
+
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		if keepSample(0.5, payloadFingerprint(transaction, sentryFingerprintPaths)) {
+			kept++
+		}
+	}
+	if kept == 0 || kept == 1000 {
+		t.Errorf("keepSample() is all-or-nothing for events without fingerprint: kept %d of 1000", kept)
+	}
+}
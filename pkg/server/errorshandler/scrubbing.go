@@ -0,0 +1,33 @@
+package errorshandler
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// scrubPayload redacts personal data of Hawk catchers payload if the project has scrubbing rules
+func (handler *Handler) scrubPayload(projectId string, payload []byte) []byte {
+	projectScrubber, ok := handler.AccountsMongoDBClient.GetProjectScrubber(projectId)
+	if !ok {
+		return payload
+	}
+
+	scrubbed, redacted := projectScrubber.Scrub(payload)
+	if redacted > 0 {
+		log.Debugf("Redacted %d values in event of project %s", redacted, projectId)
+	}
+	return scrubbed
+}
+
+// scrubEnvelope redacts personal data of Sentry envelope items if the project has scrubbing rules
+func (handler *Handler) scrubEnvelope(projectId string, envelope []byte) []byte {
+	projectScrubber, ok := handler.AccountsMongoDBClient.GetProjectScrubber(projectId)
+	if !ok {
+		return envelope
+	}
+
+	scrubbed, redacted := projectScrubber.ScrubEnvelope(envelope)
+	if redacted > 0 {
+		log.Debugf("Redacted %d values in envelope of project %s", redacted, projectId)
+	}
+	return scrubbed
+}
@@ -10,8 +10,22 @@ import (
 
 	"github.com/andybalholm/brotli"
 	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
 )
 
+// sentryItemCategories maps Sentry envelope item types to rate limiting categories
+var sentryItemCategories = map[string]string{
+	"event":            "error",
+	"transaction":      "transaction",
+	"session":          "session",
+	"sessions":         "session",
+	"attachment":       "attachment",
+	"replay_event":     "replay",
+	"replay_recording": "replay",
+	"profile":          "profile",
+	"check_in":         "monitor",
+}
+
 func decompressGzipString(gzipString []byte) ([]byte, error) {
 	reader, err := gzip.NewReader(bytes.NewReader(gzipString))
 	if err != nil {
@@ -55,3 +69,23 @@ func getSentryKeyFromAuth(auth string) (string, error) {
 
 	return "", errors.New("sentry_key not found")
 }
+
+// getSentryItemType returns the type of the first item in Sentry envelope, e.g. "event" or "transaction".
+// Envelope starts with a header line followed by the first item header line.
+func getSentryItemType(envelope []byte) string {
+	lines := bytes.SplitN(envelope, []byte("\n"), 3)
+	if len(lines) < 2 {
+		return ""
+	}
+	return gjson.GetBytes(lines[1], "type").String()
+}
+
+// getSentryCategory returns rate limiting category of the first item in Sentry envelope, e.g. "sentry/error".
+func getSentryCategory(envelope []byte) string {
+	category := "default"
+	if itemCategory, ok := sentryItemCategories[getSentryItemType(envelope)]; ok {
+		category = itemCategory
+	}
+
+	return "sentry/" + category
+}
@@ -26,3 +26,44 @@ func TestGetSentryKeyFromAuth(t *testing.T) {
 		}
 	}
 }
+
+func TestGetSentryCategory(t *testing.T) {
+	tests := []struct {
+		envelope string
+		expected string
+	}{
+		{"{\"event_id\":\"9ec79c33ec9942ab8353589fcb2e04dc\"}\n{\"type\":\"event\",\"length\":2}\n{}", "sentry/error"},
+		{"{}\n{\"type\":\"transaction\"}\n{}\n", "sentry/transaction"},
+		{"{}\n{\"type\":\"replay_recording\",\"length\":3}\nabc", "sentry/replay"},
+		{"{}\n{\"type\":\"unknown_item\"}\n{}", "sentry/default"},
+		{"{}", "sentry/default"},
+		{"", "sentry/default"},
+	}
+
+	for _, tt := range tests {
+		result := getSentryCategory([]byte(tt.envelope))
+		if result != tt.expected {
+			t.Errorf("getSentryCategory(%q) = %q, want %q", tt.envelope, result, tt.expected)
+		}
+	}
+}
+
+func TestGetSentryItemType(t *testing.T) {
+	tests := []struct {
+		envelope string
+		expected string
+	}{
+		{"{\"event_id\":\"9ec79c33ec9942ab8353589fcb2e04dc\"}\n{\"type\":\"event\",\"length\":2}\n{}", "event"},
+		{"{}\n{\"type\":\"transaction\"}\n{}\n", "transaction"},
+		{"{}\n{\"length\":2}\n{}", ""},
+		{"{}", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		result := getSentryItemType([]byte(tt.envelope))
+		if result != tt.expected {
+			t.Errorf("getSentryItemType(%q) = %q, want %q", tt.envelope, result, tt.expected)
+		}
+	}
+}
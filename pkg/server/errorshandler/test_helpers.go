@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/codex-team/hawk.collector/pkg/tsmetrics"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -12,9 +13,9 @@ import (
 // Usage: handler.GenerateTestTimeSeriesData(projectId)
 func (handler *Handler) GenerateTestTimeSeriesData(projectId string) error {
 	metricType := "events-accepted"
-	minutelyKey := getTimeSeriesKey(projectId, metricType, "minutely", true)
-	hourlyKey := getTimeSeriesKey(projectId, metricType, "hourly", true)
-	dailyKey := getTimeSeriesKey(projectId, metricType, "daily", true)
+	minutelyKey := tsmetrics.ProjectKey(projectId, metricType, "minutely", true)
+	hourlyKey := tsmetrics.ProjectKey(projectId, metricType, "hourly", true)
+	dailyKey := tsmetrics.ProjectKey(projectId, metricType, "daily", true)
 
 	// Delete existing keys to avoid accumulation
 	log.Infof("Deleting existing test data keys for project %s...", projectId)
@@ -0,0 +1,57 @@
+package errorshandler
+
+import (
+	"time"
+
+	"github.com/codex-team/hawk.collector/pkg/timestamps"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+// Clock skew metrics
+var (
+	// Difference between the collector and client clocks, negative values mean the client clock is ahead
+	collectorClientClockSkew = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "collector_client_clock_skew_seconds",
+		Help:    "Difference between the collector and client clocks for events with measurable skew",
+		Buckets: []float64{-86400, -3600, -600, -60, -10, -1, 0, 1, 10, 60, 600, 3600, 86400},
+	})
+
+	// Events sent long after they occurred
+	collectorEventsBuffered = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "collector_events_buffered_total",
+		Help: "Total number of events sent by clients long after they occurred",
+	})
+
+	// Events rejected because of timestamps in the future
+	collectorEventsRejectedFuture = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "collector_events_rejected_future_timestamp_total",
+		Help: "Total number of events rejected because of timestamps too far in the future",
+	})
+)
+
+// normalizeTiming returns the timing of the event by its client timestamp and the optional client send time
+func (handler *Handler) normalizeTiming(received time.Time, occurred, sent gjson.Result) (timestamps.Timing, error) {
+	occurredAt, _ := timestamps.Parse(occurred)
+	sentAt, _ := timestamps.Parse(sent)
+
+	timing, err := handler.Timestamps.Normalize(received, occurredAt, sentAt)
+	if err != nil {
+		collectorEventsRejectedFuture.Inc()
+		return timing, err
+	}
+
+	if timing.SkewKnown {
+		collectorClientClockSkew.Observe(float64(timing.Skew) / 1000)
+		if timing.Skew != 0 {
+			log.Tracef("Client clock skew is %d ms", timing.Skew)
+		}
+	}
+	if timing.Buffered {
+		collectorEventsBuffered.Inc()
+	}
+
+	return timing, nil
+}
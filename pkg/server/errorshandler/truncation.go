@@ -0,0 +1,71 @@
+package errorshandler
+
+import (
+	"github.com/codex-team/hawk.collector/pkg/accounts"
+	"github.com/codex-team/hawk.collector/pkg/truncate"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"github.com/valyala/fasthttp"
+)
+
+// maxMessageSize returns the maximum size of accepted error messages
+func (handler *Handler) maxMessageSize() int {
+	if handler.TruncateOversized && handler.MaxTruncatedMessageSize > handler.MaxErrorCatcherMessageSize {
+		return handler.MaxTruncatedMessageSize
+	}
+	return handler.MaxErrorCatcherMessageSize
+}
+
+// truncatePayload trims the payload to fit into size bytes.
+// Returns false if truncation is disabled or the payload could not be trimmed enough.
+// Truncated events are recorded as "events-truncated" metric separately from rejected "events-too-large".
+func (handler *Handler) truncatePayload(projectId string, payload []byte, size int) ([]byte, bool) {
+	if !handler.TruncateOversized {
+		handler.ErrorsRejectedMessageTooLarge.Inc()
+		handler.recordProjectMetrics(projectId, "events-too-large", false)
+		return nil, false
+	}
+
+	truncated, ok := truncate.Truncate(payload, size)
+	if !ok {
+		log.Warnf("Event of project %s with size %d cannot be truncated to %d", projectId, len(payload), size)
+		handler.ErrorsRejectedMessageTooLarge.Inc()
+		handler.recordProjectMetrics(projectId, "events-too-large", false)
+		return nil, false
+	}
+
+	log.Debugf("Event of project %s is truncated from %d to %d bytes", projectId, len(payload), len(truncated))
+	handler.ErrorsTruncated.Inc()
+	handler.recordProjectMetrics(projectId, "events-truncated", false)
+	return truncated, true
+}
+
+// rejectTooLarge rejects the request exceeding the maximum size by its Content-Length.
+// The rejection is recorded as "events-too-large" metric of the project if the integration secret is known.
+func (handler *Handler) rejectTooLarge(ctx *fasthttp.RequestCtx, integrationSecret string) {
+	handler.ErrorsRejectedMessageTooLarge.Inc()
+	log.Warnf("Incoming request with size %d", ctx.Request.Header.ContentLength())
+	if projectId, ok := handler.AccountsMongoDBClient.GetValidToken(integrationSecret); ok {
+		handler.recordProjectMetrics(projectId, "events-too-large", false)
+	}
+	sendAnswerHTTP(ctx, ResponseMessage{Code: 400, Error: true, Message: "Request is too large"})
+}
+
+// prefixIntegrationSecret returns the integration secret of the token found in the first size bytes of the body,
+// so the oversized body is not parsed entirely. Returns empty string if the token is not found in the prefix.
+func prefixIntegrationSecret(body []byte, size int) string {
+	if len(body) > size {
+		body = body[:size]
+	}
+
+	token := gjson.GetBytes(body, "token")
+	if token.Type != gjson.String {
+		return ""
+	}
+
+	integrationSecret, err := accounts.DecodeToken(token.String())
+	if err != nil {
+		return ""
+	}
+	return integrationSecret
+}
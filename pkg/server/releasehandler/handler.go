@@ -10,7 +10,10 @@ import (
 	"github.com/codex-team/hawk.collector/pkg/accounts"
 
 	"github.com/codex-team/hawk.collector/pkg/broker"
+	"github.com/codex-team/hawk.collector/pkg/iplimit"
+	"github.com/codex-team/hawk.collector/pkg/ratelimit"
 	"github.com/codex-team/hawk.collector/pkg/redis"
+	"github.com/codex-team/hawk.collector/pkg/tsmetrics"
 	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
 )
@@ -22,11 +25,22 @@ type Handler struct {
 	JwtSecret                    string
 	RedisClient                  *redis.RedisClient
 	AccountsMongoDBClient        *accounts.AccountsMongoDBClient
+	RateLimiter                  *ratelimit.Limiter
+
+	// Metrics accumulates project time series written to Redis periodically
+	Metrics *tsmetrics.Aggregator
+
+	// IPLimiter counts requests with invalid tokens per client IP, nil disables the limit
+	IPLimiter *iplimit.Limiter
 }
 
 const AddReleaseType string = "add-release"
 
-func (handler *Handler) process(form *multipart.Form, token string) ResponseMessage {
+// ReleaseCategory is the rate limits category of release uploads.
+// Releases are limited only if the category has own limits and don't consume events quota.
+const ReleaseCategory string = "release"
+
+func (handler *Handler) process(form *multipart.Form, token, ip string) ResponseMessage {
 	err, release := getSingleFormValue(form, "release")
 	if err != nil {
 		return ResponseMessage{400, true, fmt.Sprintf("%s", err)}
@@ -36,6 +50,7 @@ func (handler *Handler) process(form *multipart.Form, token string) ResponseMess
 	projectId, ok := handler.AccountsMongoDBClient.GetValidToken(token)
 	if !ok {
 		log.Debugf("Token %s is not in the accounts cache", token)
+		handler.IPLimiter.Invalid(ip)
 		return ResponseMessage{400, true, fmt.Sprintf("Integration token invalid: %s", token)}
 	}
 	log.Debugf("Found project with ID %s for integration token %s", projectId, token)
@@ -51,6 +66,23 @@ func (handler *Handler) process(form *multipart.Form, token string) ResponseMess
 		return ResponseMessage{402, true, "Project has exceeded the events limit"}
 	}
 
+	if releaseLimits, ok := projectLimits.ForCategory(ReleaseCategory); ok {
+		counterId := projectId + ":" + ReleaseCategory
+		result, err := handler.RateLimiter.Allow(counterId, redis.RateLimit{
+			ID:        counterId,
+			Limit:     releaseLimits.EventsLimit,
+			Period:    releaseLimits.EventsPeriod,
+			Algorithm: releaseLimits.Algorithm,
+		})
+		if err != nil {
+			log.Errorf("[release] Failed to update rate limit: %s", err)
+			return ResponseMessage{402, true, "Failed to update rate limit"}
+		}
+		if result.Exceeded != -1 {
+			return ResponseMessage{402, true, "Rate limit exceeded"}
+		}
+	}
+
 	var files []ReleaseFile
 
 	for _, v := range form.File { // for each File part in multipart form
@@ -88,5 +120,21 @@ func (handler *Handler) process(form *multipart.Form, token string) ResponseMess
 
 	// send serialized message to a broker
 	handler.Broker.Chan <- broker.Message{Payload: rawMessage, Route: handler.ReleaseExchange}
+	handler.recordProjectMetrics(projectId, "releases-uploaded")
 	return ResponseMessage{200, false, "OK"}
 }
+
+// recordProjectMetrics records a release of the project to Redis TimeSeries
+func (handler *Handler) recordProjectMetrics(projectId, metricType string) {
+	minutelyKey := tsmetrics.ProjectKey(projectId, metricType, "minutely", false)
+	hourlyKey := tsmetrics.ProjectKey(projectId, metricType, "hourly", false)
+	dailyKey := tsmetrics.ProjectKey(projectId, metricType, "daily", false)
+
+	labels := map[string]string{
+		"type":    "release",
+		"status":  metricType,
+		"project": projectId,
+	}
+
+	handler.Metrics.Count(minutelyKey, hourlyKey, dailyKey, labels, 1)
+}
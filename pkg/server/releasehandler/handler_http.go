@@ -5,6 +5,7 @@ import (
 	"errors"
 
 	"github.com/codex-team/hawk.collector/pkg/accounts"
+	"github.com/codex-team/hawk.collector/pkg/clientip"
 	"github.com/codex-team/hawk.collector/pkg/hawk"
 	log "github.com/sirupsen/logrus"
 	"github.com/valyala/fasthttp"
@@ -25,6 +26,7 @@ func (handler *Handler) HandleHTTP(ctx *fasthttp.RequestCtx) {
 	token := ctx.Request.Header.Peek("Authorization")
 	if len(token) < 8 {
 		log.Warnf("[release] Missing header (len=%d): %s", len(token), token)
+		handler.IPLimiter.Invalid(clientIP(ctx))
 		sendAnswerHTTP(ctx, ResponseMessage{400, true, "Provide Authorization header"})
 		return
 	}
@@ -43,17 +45,24 @@ func (handler *Handler) HandleHTTP(ctx *fasthttp.RequestCtx) {
 	integrationSecret, err := accounts.DecodeToken(string(token))
 	if err != nil {
 		log.Warnf("[release] Token decoding error: %s", err)
+		handler.IPLimiter.Invalid(clientIP(ctx))
 		sendAnswerHTTP(ctx, ResponseMessage{400, true, "Token decoding error"})
 		return
 	}
 
 	// process raw body via unified sourcemap handler
-	response := handler.process(form, integrationSecret)
+	response := handler.process(form, integrationSecret, clientIP(ctx))
 	log.Debugf("[release] Multipart form response: %s", response.Message)
 
 	sendAnswerHTTP(ctx, response)
 }
 
+// clientIP returns the client IP resolved by the server
+func clientIP(ctx *fasthttp.RequestCtx) string {
+	ip, _ := ctx.UserValue(clientip.UserValueKey).(string)
+	return ip
+}
+
 // Send ResponseMessage in JSON with statusCode set
 func sendAnswerHTTP(ctx *fasthttp.RequestCtx, r ResponseMessage) {
 	ctx.Response.SetStatusCode(r.Code)
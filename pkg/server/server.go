@@ -4,18 +4,30 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
+	"math"
+	"net"
 	"strconv"
 
 	"github.com/codex-team/hawk.collector/pkg/accounts"
 
 	"github.com/codex-team/hawk.collector/cmd"
 	"github.com/codex-team/hawk.collector/pkg/alerts"
+	"github.com/codex-team/hawk.collector/pkg/autoban"
 	"github.com/codex-team/hawk.collector/pkg/broker"
+	"github.com/codex-team/hawk.collector/pkg/clientip"
+	"github.com/codex-team/hawk.collector/pkg/dedup"
+	"github.com/codex-team/hawk.collector/pkg/enrichment"
 	"github.com/codex-team/hawk.collector/pkg/hawk"
+	"github.com/codex-team/hawk.collector/pkg/iplimit"
+	"github.com/codex-team/hawk.collector/pkg/ratelimit"
 	"github.com/codex-team/hawk.collector/pkg/redis"
+	"github.com/codex-team/hawk.collector/pkg/schemas"
 	"github.com/codex-team/hawk.collector/pkg/server/errorshandler"
 	"github.com/codex-team/hawk.collector/pkg/server/releasehandler"
+	"github.com/codex-team/hawk.collector/pkg/spikeprotection"
+	"github.com/codex-team/hawk.collector/pkg/timestamps"
+	"github.com/codex-team/hawk.collector/pkg/tsmetrics"
+	"github.com/pires/go-proxyproto"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	log "github.com/sirupsen/logrus"
@@ -37,17 +49,110 @@ type Server struct {
 	RedisClient           *redis.RedisClient
 	AccountsMongoDBClient *accounts.AccountsMongoDBClient
 
+	// in-process pre-filter for Redis rate limits
+	RateLimiter *ratelimit.Limiter
+
+	// dynamic caps of projects intake based on their hourly baseline
+	SpikeProtection *spikeprotection.Protector
+
+	// Metrics accumulates project and workspace time series written to Redis every MetricsFlushPeriod
+	Metrics *tsmetrics.Aggregator
+
+	// suppressor of duplicate events, nil if deduplication is disabled
+	Deduplicator *dedup.Deduplicator
+
+	// JSON Schemas of payloads per catcher type, nil if validation is disabled
+	Schemas *schemas.Registry
+
+	// builder of the client context attached to events, nil if enrichment is disabled
+	Enricher *enrichment.Enricher
+
+	// resolver of client IPs behind trusted proxies
+	ClientIPResolver *clientip.Resolver
+
+	// per-IP rate limits applied before routing, nil if disabled
+	IPLimiter *iplimit.Limiter
+
+	// temporary bans of IPs exceeding BlacklistThreshold, nil if automatic banning is disabled
+	AutoBan *autoban.Banner
+
 	BlacklistThreshold int
 	NotifyURL          string
 }
 
 // New creates new server and initiates it with link to the broker and copy of configuration parameters
 func New(configuration cmd.Config, brokerClient *broker.Broker, redisClient *redis.RedisClient, accountsMongoDBClient *accounts.AccountsMongoDBClient, threshold int, notifyURL string) *Server {
+	var deduplicator *dedup.Deduplicator
+	if configuration.DedupWindow > 0 {
+		deduplicator = dedup.New(configuration.DedupWindow, configuration.DedupCacheSize, errorshandler.ForwardRepeated(brokerClient))
+	}
+
+	var registry *schemas.Registry
+	if configuration.CatcherSchemasDir != "" {
+		var err error
+		registry, err = schemas.Load(configuration.CatcherSchemasDir, configuration.SchemaValidationMode)
+		cmd.FailOnError(err, "Failed to load catcher schemas")
+	}
+
+	resolver, err := clientip.New(configuration.TrustedProxies, configuration.TrustedProxyHeader)
+	cmd.FailOnError(err, "Failed to parse trusted proxies")
+
+	var enricher *enrichment.Enricher
+	if configuration.EnrichmentEnabled {
+		enricher, err = enrichment.New(configuration.GeoIPDatabasePath)
+		cmd.FailOnError(err, "Failed to load GeoIP database")
+	}
+
+	rateLimiter := ratelimit.New(redisClient, configuration.RateLimitLeaseSize, configuration.RateLimitLeaseTTL)
+
+	var ipLimiter *iplimit.Limiter
+	if configuration.IPRateLimit > 0 || configuration.IPInvalidTokenLimit > 0 {
+		var shared *ratelimit.Limiter
+		if configuration.IPRateLimitShared {
+			// IP buckets are checked one request at a time, leases would reserve events of IPs sending a single request
+			shared = ratelimit.New(redisClient, 1, configuration.RateLimitLeaseTTL)
+		}
+		ipLimiter = iplimit.New(
+			iplimit.Budget{Limit: configuration.IPRateLimit, Period: configuration.IPRateLimitPeriod},
+			iplimit.Budget{Limit: configuration.IPInvalidTokenLimit, Period: configuration.IPInvalidTokenPeriod},
+			configuration.IPInvalidTokenPenalty,
+			shared,
+		)
+	}
+
+	var banner *autoban.Banner
+	if configuration.AutoBanEnabled {
+		banner, err = autoban.New(redisClient, configuration.AutoBanDuration, configuration.AutoBanMaxDuration, configuration.AutoBanOffenceMemory, configuration.AutoBanAllowlist, notifyURL)
+		cmd.FailOnError(err, "Failed to parse auto ban allowlist")
+	}
+
+	metricsRetention := tsmetrics.Retention{
+		Minutely: configuration.MetricsMinutelyRetention,
+		Hourly:   configuration.MetricsHourlyRetention,
+		Daily:    configuration.MetricsDailyRetention,
+	}
+
+	// compaction rules are rejected by Redis Cluster since the source and destination keys are in different slots
+	metricsCompaction := configuration.MetricsCompaction
+	if metricsCompaction && redisClient.IsCluster() {
+		log.Warnf("Metrics compaction is not supported by Redis Cluster, hourly and daily series are written by the collector")
+		metricsCompaction = false
+	}
+
 	return &Server{
 		Broker:                brokerClient,
 		Config:                configuration,
 		RedisClient:           redisClient,
 		AccountsMongoDBClient: accountsMongoDBClient,
+		RateLimiter:           rateLimiter,
+		SpikeProtection:       spikeprotection.New(redisClient, accountsMongoDBClient, configuration.SpikeProtectionBaselineHours, configuration.SpikeProtectionMinBaseline, notifyURL),
+		Deduplicator:          deduplicator,
+		Metrics:               tsmetrics.New(redisClient, metricsRetention, metricsCompaction),
+		Schemas:               registry,
+		Enricher:              enricher,
+		ClientIPResolver:      resolver,
+		IPLimiter:             ipLimiter,
+		AutoBan:               banner,
 		BlacklistThreshold:    threshold,
 		NotifyURL:             notifyURL,
 	}
@@ -65,14 +170,30 @@ func (s *Server) Run() {
 
 	// handler of error messages via HTTP and websocket protocols
 	s.ErrorsHandler = errorshandler.Handler{
-		Broker:                     s.Broker,
-		MaxErrorCatcherMessageSize: s.Config.MaxErrorCatcherMessageSize,
+		Broker:                        s.Broker,
+		MaxErrorCatcherMessageSize:    s.Config.MaxErrorCatcherMessageSize,
+		TruncateOversized:             s.Config.TruncateOversizedEvents,
+		MaxTruncatedMessageSize:       s.Config.MaxTruncatedMessageSize,
 		ErrorsBlockedByLimit:          promauto.NewCounter(prometheus.CounterOpts{Name: "collector_errors_blocked_by_limit_total"}),
 		ErrorsProcessed:               promauto.NewCounter(prometheus.CounterOpts{Name: "collector_errors_processed_ops_total"}),
 		ErrorsRejectedMessageTooLarge: promauto.NewCounter(prometheus.CounterOpts{Name: "collector_errors_rejected_message_too_large_total"}),
-		RedisClient:                s.RedisClient,
-		AccountsMongoDBClient:      s.AccountsMongoDBClient,
-		NonDefaultQueues:           errorshandler.GetQueueCache(s.Config.NonDefaultQueues),
+		ErrorsTruncated:               promauto.NewCounter(prometheus.CounterOpts{Name: "collector_errors_truncated_total"}),
+		RedisClient:                   s.RedisClient,
+		AccountsMongoDBClient:         s.AccountsMongoDBClient,
+		RateLimiter:                   s.RateLimiter,
+		SpikeProtection:               s.SpikeProtection,
+		Metrics:                       s.Metrics,
+		Deduplicator:                  s.Deduplicator,
+		Schemas:                       s.Schemas,
+		Enricher:                      s.Enricher,
+		IPLimiter:                     s.IPLimiter,
+		FingerprintPaths:              errorshandler.GetFingerprintPaths(s.Config.DedupFingerprintPaths),
+		NonDefaultQueues:              errorshandler.GetQueueCache(s.Config.NonDefaultQueues),
+		Timestamps: timestamps.Normalizer{
+			MaxFuture:         s.Config.MaxFutureTimestamp,
+			RejectFuture:      s.Config.RejectFutureTimestamps,
+			BufferedThreshold: s.Config.BufferedEventThreshold,
+		},
 	}
 
 	// handler of sourcemap messages via HTTP
@@ -82,36 +203,64 @@ func (s *Server) Run() {
 		MaxReleaseCatcherMessageSize: s.Config.MaxReleaseCatcherMessageSize,
 		RedisClient:                  s.RedisClient,
 		AccountsMongoDBClient:        s.AccountsMongoDBClient,
+		RateLimiter:                  s.RateLimiter,
+		Metrics:                      s.Metrics,
+		IPLimiter:                    s.IPLimiter,
 	}
 
 	log.Infof("✓ collector starting on %s", s.Config.Listen)
 
-	err := fastHTTPServer.ListenAndServe(s.Config.Listen)
+	listener, err := net.Listen("tcp", s.Config.Listen)
+	cmd.FailOnError(err, "Server listen error")
+
+	// PROXY protocol headers are accepted from trusted proxies only
+	if s.Config.ProxyProtocol {
+		listener = &proxyproto.Listener{Listener: listener, Policy: s.proxyProtocolPolicy}
+		log.Infof("✓ PROXY protocol enabled")
+	}
+
+	err = fastHTTPServer.Serve(listener)
 	cmd.FailOnError(err, "Server run error")
 }
 
+// proxyProtocolPolicy uses PROXY protocol headers of trusted proxies and ignores them for other connections
+func (s *Server) proxyProtocolPolicy(upstream net.Addr) (proxyproto.Policy, error) {
+	addr, ok := upstream.(*net.TCPAddr)
+	if !ok || !s.ClientIPResolver.IsTrusted(addr.IP) {
+		return proxyproto.IGNORE, nil
+	}
+	return proxyproto.USE, nil
+}
+
 // global fasthttp entrypoint
 func (s *Server) handler(ctx *fasthttp.RequestCtx) {
 	ctx.SetContentType("text/json; charset=utf8")
 
 	var err error
-	remoteIP := string(ctx.Request.Header.Peek(http.CanonicalHeaderKey("X-Forwarded-For")))
 
-	// If X-Forwarded-For is empty, fallback to ctx.RemoteIP()
-	if remoteIP == "" {
-		remoteIP = ctx.RemoteIP().String()
+	// the forwarding header is taken into account only if the request is received from a trusted proxy
+	remoteIP := ""
+	clientIP := s.ClientIPResolver.Resolve(ctx.RemoteIP(), string(ctx.Request.Header.Peek(s.ClientIPResolver.Header)))
+	if clientIP != nil && !clientIP.IsUnspecified() {
+		remoteIP = clientIP.String()
 	}
 
 	if remoteIP != "" {
+		ctx.SetUserValue(clientip.UserValueKey, remoteIP)
+
 		isBlocked := s.RedisClient.CheckBlacklist(remoteIP)
 		if isBlocked {
 			ctx.Error("Too Many Requests", fasthttp.StatusTooManyRequests)
 			return
 		}
 
-		err = s.RedisClient.IncrementIP(remoteIP)
-		if err != nil {
-			log.Errorf("failed to increment IP in database: %s", err.Error())
+		// requests rejected by the per-IP limiter are counted as well, so flooding IPs are reported and banned
+		s.RedisClient.IncrementIP(remoteIP)
+
+		if allowed, retryAfter := s.IPLimiter.Allow(remoteIP); !allowed {
+			ctx.Response.Header.Set("Retry-After", strconv.FormatInt(int64(math.Ceil(retryAfter.Seconds())), 10))
+			ctx.Error("Too Many Requests", fasthttp.StatusTooManyRequests)
+			return
 		}
 	} else {
 		log.Errorf("failed to determine remote IP")
@@ -154,19 +303,35 @@ func (s *Server) handler(ctx *fasthttp.RequestCtx) {
 }
 
 func (s *Server) UpdateBlacklist() error {
-	ipAddrs, requests, err := s.RedisClient.LoadBlacklist()
+	update, err := s.RedisClient.LoadBlacklist()
 	if err != nil {
 		return err
 	}
 
-	if len(ipAddrs) == 0 || len(requests) == 0 {
+	if s.AutoBan != nil {
+		for _, ip := range update.Expired {
+			s.AutoBan.Unbanned(ip)
+		}
+	}
+
+	if len(update.IPs) == 0 || len(update.Requests) == 0 {
 		return nil
 	}
 
-	for i := 0; i < len(ipAddrs); i++ {
-		requestsQty, _ := strconv.Atoi(requests[i])
+	for i := 0; i < len(update.IPs); i++ {
+		requestsQty, _ := strconv.Atoi(update.Requests[i])
 		if requestsQty >= s.BlacklistThreshold {
-			err = alerts.Notify(s.NotifyURL, fmt.Sprintf("Hawk Collector (production) ⚠️\n\nTo many messages from %s\n%s", ipAddrs[i], requests[i]))
+			if s.AutoBan != nil {
+				banned, err := s.AutoBan.Ban(update.IPs[i], requestsQty)
+				if err != nil {
+					log.Errorf("failed to ban IP %s: %s", update.IPs[i], err)
+				}
+				if banned {
+					continue
+				}
+			}
+
+			err = alerts.Notify(s.NotifyURL, fmt.Sprintf("Hawk Collector (production) ⚠️\n\nTo many messages from %s\n%s", update.IPs[i], update.Requests[i]))
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,163 @@
+package spikeprotection
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/codex-team/hawk.collector/pkg/accounts"
+	"github.com/codex-team/hawk.collector/pkg/alerts"
+	"github.com/codex-team/hawk.collector/pkg/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+// acceptedHourlyKey is the hourly series of accepted events recorded by errorshandler.recordProjectMetrics,
+// only complete hours are read since the current hour of compacted series is not stored yet
+const acceptedHourlyKey = "ts:collector-project-events-accepted:%s:hourly"
+
+// Protector dynamically caps intake of projects at a multiple of their rolling hourly baseline,
+// so a project which suddenly sends orders of magnitude more events after a bad deploy doesn't flood workers.
+type Protector struct {
+	mx             sync.RWMutex
+	redisClient    *redis.RedisClient
+	accountsClient *accounts.AccountsMongoDBClient
+
+	// BaselineHours is the number of complete hours used to compute the baseline
+	BaselineHours int
+
+	// MinBaseline is the minimal baseline (events per hour), so small projects are not capped at a few events
+	MinBaseline int64
+
+	// NotifyURL is the address to send alerts when a project is capped
+	NotifyURL string
+
+	// hourly caps of protected projects with known baseline
+	caps map[string]int64
+
+	// last alert time per project to notify once per hour
+	alerted map[string]time.Time
+}
+
+// New creates spike protector
+func New(redisClient *redis.RedisClient, accountsClient *accounts.AccountsMongoDBClient, baselineHours int, minBaseline int64, notifyURL string) *Protector {
+	return &Protector{
+		redisClient:    redisClient,
+		accountsClient: accountsClient,
+		BaselineHours:  baselineHours,
+		MinBaseline:    minBaseline,
+		NotifyURL:      notifyURL,
+		caps:           make(map[string]int64),
+		alerted:        make(map[string]time.Time),
+	}
+}
+
+// hourlyCap computes the cap from hourly samples of the last hours complete hours before now.
+// Missing hours are counted as zero. Returns false if there are no samples to build the baseline.
+func hourlyCap(samples []redis.TSSample, hours int, now time.Time, multiplier float64, minBaseline int64) (int64, bool) {
+	if hours <= 0 || len(samples) == 0 {
+		return 0, false
+	}
+
+	currentHour := now.UTC().Truncate(time.Hour).UnixNano() / int64(time.Millisecond)
+	from := currentHour - int64(hours)*int64(time.Hour/time.Millisecond)
+
+	total := 0.0
+	found := false
+	for _, sample := range samples {
+		if sample.Timestamp < from || sample.Timestamp >= currentHour {
+			continue
+		}
+		total += sample.Value
+		found = true
+	}
+	if !found {
+		return 0, false
+	}
+
+	baseline := math.Max(total/float64(hours), float64(minBaseline))
+	return int64(math.Ceil(baseline * multiplier)), true
+}
+
+// Update recomputes baselines of protected projects, should be run periodically.
+// Projects whose history failed to load keep their previous caps.
+func (p *Protector) Update() error {
+	now := time.Now()
+	to := now.UnixNano() / int64(time.Millisecond)
+	from := to - int64(p.BaselineHours+1)*int64(time.Hour/time.Millisecond)
+
+	caps := make(map[string]int64)
+	failed := 0
+	for projectID, multiplier := range p.accountsClient.GetSpikeProtectedProjects() {
+		samples, err := p.redisClient.TSRange(fmt.Sprintf(acceptedHourlyKey, projectID), from, to)
+		if err != nil {
+			log.Errorf("failed to get hourly events of project %s: %s", projectID, err)
+			failed++
+			if limit := p.Limit(projectID).Limit; limit > 0 {
+				caps[projectID] = limit
+			}
+			continue
+		}
+
+		if limit, ok := hourlyCap(samples, p.BaselineHours, now, multiplier, p.MinBaseline); ok {
+			caps[projectID] = limit
+		}
+	}
+
+	p.mx.Lock()
+	p.caps = caps
+	p.pruneAlerted(now)
+	p.mx.Unlock()
+
+	log.Debugf("Spike protection caps updated for %d projects (%d failed)", len(caps), failed)
+	log.Tracef("Current spike protection caps: %+v", caps)
+
+	return nil
+}
+
+// pruneAlerted forgets alerts sent more than an hour ago, since they don't suppress new alerts anymore
+func (p *Protector) pruneAlerted(now time.Time) {
+	for projectID, last := range p.alerted {
+		if now.Sub(last) >= time.Hour {
+			delete(p.alerted, projectID)
+		}
+	}
+}
+
+// Limit returns the hourly rate limit of the project, Limit is 0 if the project is not protected
+func (p *Protector) Limit(projectID string) redis.RateLimit {
+	p.mx.RLock()
+	defer p.mx.RUnlock()
+
+	return redis.RateLimit{
+		ID:     projectID + ":spike",
+		Limit:  p.caps[projectID],
+		Period: int64(time.Hour / time.Second),
+	}
+}
+
+// Alert notifies about the project capped by spike protection at most once per hour
+func (p *Protector) Alert(projectID string) {
+	now := time.Now()
+
+	p.mx.Lock()
+	if last, ok := p.alerted[projectID]; ok && now.Sub(last) < time.Hour {
+		p.mx.Unlock()
+		return
+	}
+	p.alerted[projectID] = now
+	limit := p.caps[projectID]
+	p.mx.Unlock()
+
+	log.Warnf("Spike protection capped project %s at %d events per hour", projectID, limit)
+	if p.NotifyURL == "" {
+		return
+	}
+
+	go func() {
+		err := alerts.Notify(p.NotifyURL, fmt.Sprintf("Hawk Collector ⚠️\n\nSpike protection capped project %s at %d events per hour", projectID, limit))
+		if err != nil {
+			log.Errorf("failed to send spike protection alert: %s", err)
+		}
+	}()
+}
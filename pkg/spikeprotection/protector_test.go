@@ -0,0 +1,77 @@
+package spikeprotection
+
+import (
+	"testing"
+	"time"
+
+	"github.com/codex-team/hawk.collector/pkg/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHourlyCap(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	sample := func(hour int, value float64) redis.TSSample {
+		timestamp := time.Date(2024, 5, 1, hour, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+		return redis.TSSample{Timestamp: timestamp, Value: value}
+	}
+
+	tests := []struct {
+		name       string
+		samples    []redis.TSSample
+		multiplier float64
+		wantCap    int64
+		wantOk     bool
+	}{
+		{
+			name:       "no history",
+			multiplier: 10,
+			wantOk:     false,
+		},
+		{
+			name:       "average over complete hours with missing hours as zero",
+			samples:    []redis.TSSample{sample(9, 300), sample(11, 300)},
+			multiplier: 10,
+			wantCap:    2000,
+			wantOk:     true,
+		},
+		{
+			name:       "current hour and old hours are ignored",
+			samples:    []redis.TSSample{sample(6, 100000), sample(10, 600), sample(12, 100000)},
+			multiplier: 2.5,
+			wantCap:    500,
+			wantOk:     true,
+		},
+		{
+			name:       "only current hour",
+			samples:    []redis.TSSample{sample(12, 100)},
+			multiplier: 10,
+			wantOk:     false,
+		},
+		{
+			name:       "small projects get minimal baseline",
+			samples:    []redis.TSSample{sample(11, 3)},
+			multiplier: 10,
+			wantCap:    1000,
+			wantOk:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit, ok := hourlyCap(tt.samples, 3, now, tt.multiplier, 100)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.wantCap, limit)
+		})
+	}
+}
+
+func TestPruneAlerted(t *testing.T) {
+	now := time.Now()
+	p := New(nil, nil, 24, 100, "")
+	p.alerted["recent"] = now.Add(-30 * time.Minute)
+	p.alerted["old"] = now.Add(-2 * time.Hour)
+
+	p.pruneAlerted(now)
+	assert.Contains(t, p.alerted, "recent")
+	assert.NotContains(t, p.alerted, "old")
+}
@@ -0,0 +1,110 @@
+package timestamps
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// ErrFuture is returned for events which occurred too far in the future if such events are rejected
+var ErrFuture = errors.New("event timestamp is too far in the future")
+
+// millisecondsThreshold separates numeric timestamps in milliseconds from timestamps in seconds
+const millisecondsThreshold = 1e11
+
+// Timing is the normalized time of the event in milliseconds
+type Timing struct {
+	// OccurredAt is the client time of the event corrected by the clock skew
+	OccurredAt int64
+
+	// ReceivedAt is the time the collector received the event
+	ReceivedAt int64
+
+	// Skew is the difference between the collector and the client clocks, 0 if unknown
+	Skew int64
+
+	// SkewKnown is set if the skew is measured by the client send time or by the event timestamp in the future
+	SkewKnown bool
+
+	// Buffered is set if the event was sent long after it occurred, e.g. kept by the client offline
+	Buffered bool
+}
+
+// Normalizer detects client clock skew and buffered events
+type Normalizer struct {
+	// MaxFuture is the tolerance for corrected event timestamps in the future if RejectFuture is set
+	MaxFuture time.Duration
+
+	// RejectFuture rejects events too far in the future, otherwise timestamps in the future are clamped to the receive time
+	RejectFuture bool
+
+	// BufferedThreshold is the delay after which the event is considered buffered
+	BufferedThreshold time.Duration
+}
+
+// Normalize returns timing of the event received at received.
+// occurred is the client time of the event and sent is the client time of sending, zero values if unknown.
+// Without the send time, a timestamp in the future is considered the clock skew and a timestamp in the past the buffering delay.
+func (normalizer Normalizer) Normalize(received, occurred, sent time.Time) (Timing, error) {
+	timing := Timing{ReceivedAt: milliseconds(received), OccurredAt: milliseconds(received)}
+	if occurred.IsZero() {
+		return timing, nil
+	}
+
+	corrected := occurred
+	if !sent.IsZero() {
+		skew := received.Sub(sent)
+		timing.Skew = skew.Milliseconds()
+		timing.SkewKnown = true
+		corrected = occurred.Add(skew)
+		timing.Buffered = sent.Sub(occurred) > normalizer.BufferedThreshold
+	} else if delay := received.Sub(occurred); delay < 0 {
+		timing.Skew = delay.Milliseconds()
+		timing.SkewKnown = true
+	} else {
+		timing.Buffered = delay > normalizer.BufferedThreshold
+	}
+
+	if normalizer.RejectFuture && corrected.Sub(received) > normalizer.MaxFuture {
+		return timing, ErrFuture
+	}
+
+	// the event could not occur after it is received
+	if corrected.After(received) {
+		corrected = received
+	}
+
+	timing.OccurredAt = milliseconds(corrected)
+	return timing, nil
+}
+
+// Parse returns the time of numeric timestamp in seconds or milliseconds or RFC 3339 string, false if the value is invalid
+func Parse(value gjson.Result) (time.Time, bool) {
+	switch value.Type {
+	case gjson.Number:
+		timestamp := value.Float()
+		if timestamp <= 0 {
+			return time.Time{}, false
+		}
+		if timestamp < millisecondsThreshold {
+			timestamp *= 1000
+		}
+		whole, fraction := math.Modf(timestamp)
+		return time.Unix(0, int64(whole)*int64(time.Millisecond)+int64(fraction*float64(time.Millisecond))), true
+	case gjson.String:
+		parsed, err := time.Parse(time.RFC3339Nano, value.Str)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return parsed, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// milliseconds returns Unix time in milliseconds
+func milliseconds(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,82 @@
+package timestamps
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestNormalize(t *testing.T) {
+	normalizer := Normalizer{MaxFuture: time.Minute, BufferedThreshold: 5 * time.Minute}
+	received := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ms := func(t time.Time) int64 { return t.UnixNano() / int64(time.Millisecond) }
+
+	tests := []struct {
+		name     string
+		occurred time.Time
+		sent     time.Time
+		want     Timing
+	}{
+		{
+			name: "unknown client time",
+			want: Timing{OccurredAt: ms(received), ReceivedAt: ms(received)},
+		},
+		{
+			name:     "recent event",
+			occurred: received.Add(-2 * time.Second),
+			want:     Timing{OccurredAt: ms(received.Add(-2 * time.Second)), ReceivedAt: ms(received)},
+		},
+		{
+			name:     "buffered offline",
+			occurred: received.Add(-time.Hour),
+			want:     Timing{OccurredAt: ms(received.Add(-time.Hour)), ReceivedAt: ms(received), Buffered: true},
+		},
+		{
+			name:     "client clock ahead is clamped",
+			occurred: received.Add(10 * time.Minute),
+			want:     Timing{OccurredAt: ms(received), ReceivedAt: ms(received), Skew: -600000, SkewKnown: true},
+		},
+		{
+			name:     "skew corrected by send time",
+			occurred: received.Add(50 * time.Minute),
+			sent:     received.Add(time.Hour),
+			want:     Timing{OccurredAt: ms(received.Add(-10 * time.Minute)), ReceivedAt: ms(received), Skew: -3600000, SkewKnown: true, Buffered: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timing, err := normalizer.Normalize(received, tt.occurred, tt.sent)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, timing)
+		})
+	}
+}
+
+func TestNormalizeRejectFuture(t *testing.T) {
+	normalizer := Normalizer{MaxFuture: time.Minute, RejectFuture: true}
+	received := time.Now()
+
+	_, err := normalizer.Normalize(received, received.Add(2*time.Minute), time.Time{})
+	assert.Equal(t, ErrFuture, err)
+
+	_, err = normalizer.Normalize(received, received.Add(30*time.Second), time.Time{})
+	assert.NoError(t, err)
+}
+
+func TestParse(t *testing.T) {
+	expected := time.Date(2018, 12, 19, 7, 16, 48, 500000000, time.UTC)
+
+	for _, raw := range []string{`1545203808.5`, `1545203808500`, `"2018-12-19T07:16:48.5Z"`} {
+		parsed, ok := Parse(gjson.Parse(raw))
+		assert.True(t, ok, raw)
+		assert.True(t, expected.Equal(parsed), raw)
+	}
+
+	for _, raw := range []string{`0`, `"yesterday"`, `null`, `{}`} {
+		_, ok := Parse(gjson.Parse(raw))
+		assert.False(t, ok, raw)
+	}
+}
@@ -0,0 +1,128 @@
+package truncate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/tidwall/gjson"
+)
+
+// Placeholder replaces dropped values
+const Placeholder = "[Truncated]"
+
+// latestItemsKeys are arrays which keep the latest items when trimmed
+var latestItemsKeys = map[string]bool{"breadcrumbs": true}
+
+// droppableKeys are top-level objects replaced with the placeholder on the last steps
+var droppableKeys = map[string]bool{"context": true, "addons": true, "breadcrumbs": true}
+
+// limits of a single truncation step
+type limits struct {
+	// maximum length of strings in runes
+	maxString int
+
+	// maximum number of array items
+	maxArray int
+
+	// drop droppable top-level objects
+	dropObjects bool
+}
+
+// steps are applied one by one until the payload fits
+var steps = []limits{
+	{maxString: 4096, maxArray: 100},
+	{maxString: 1024, maxArray: 50},
+	{maxString: 256, maxArray: 20},
+	{maxString: 256, maxArray: 10, dropObjects: true},
+	{maxString: 64, maxArray: 5, dropObjects: true},
+}
+
+// Truncate trims the largest fields of JSON object payload (long strings, long arrays, big context and addons objects)
+// until it fits into size bytes including the "truncated" annotation with the original size.
+// Returns false if the payload could not be trimmed enough.
+func Truncate(payload []byte, size int) ([]byte, bool) {
+	if len(payload) <= size {
+		return payload, true
+	}
+
+	value := gjson.ParseBytes(payload)
+	if !value.IsObject() {
+		return nil, false
+	}
+
+	annotation := fmt.Sprintf(`"truncated":{"originalSize":%d}`, len(payload))
+	for _, step := range steps {
+		var buffer bytes.Buffer
+		step.write(&buffer, value, 0, "")
+
+		// replace closing brace with the annotation
+		buffer.Truncate(buffer.Len() - 1)
+		if buffer.Len() > 1 {
+			buffer.WriteByte(',')
+		}
+		buffer.WriteString(annotation)
+		buffer.WriteByte('}')
+
+		if buffer.Len() <= size {
+			return buffer.Bytes(), true
+		}
+	}
+
+	return nil, false
+}
+
+func (step limits) write(buffer *bytes.Buffer, value gjson.Result, depth int, key string) {
+	switch {
+	case step.dropObjects && depth == 1 && droppableKeys[key] && (value.IsObject() || value.IsArray()):
+		writeString(buffer, Placeholder)
+	case value.IsObject():
+		buffer.WriteByte('{')
+		first := true
+		value.ForEach(func(itemKey, item gjson.Result) bool {
+			if !first {
+				buffer.WriteByte(',')
+			}
+			first = false
+			buffer.WriteString(itemKey.Raw)
+			buffer.WriteByte(':')
+			step.write(buffer, item, depth+1, itemKey.String())
+			return true
+		})
+		buffer.WriteByte('}')
+	case value.IsArray():
+		items := value.Array()
+		if len(items) > step.maxArray {
+			if latestItemsKeys[key] {
+				items = items[len(items)-step.maxArray:]
+			} else {
+				items = items[:step.maxArray]
+			}
+		}
+		buffer.WriteByte('[')
+		for i, item := range items {
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+			step.write(buffer, item, depth+1, strconv.Itoa(i))
+		}
+		buffer.WriteByte(']')
+	case value.Type == gjson.String && utf8.RuneCountInString(value.Str) > step.maxString:
+		runes := []rune(value.Str)
+		writeString(buffer, string(runes[:step.maxString])+"…")
+	default:
+		buffer.WriteString(value.Raw)
+	}
+}
+
+// writeString writes JSON encoded string without escaping HTML characters
+func writeString(buffer *bytes.Buffer, value string) {
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(value)
+
+	// Encode appends a newline
+	buffer.Truncate(buffer.Len() - 1)
+}
@@ -0,0 +1,61 @@
+package truncate
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestTruncate(t *testing.T) {
+	breadcrumbs := make([]string, 200)
+	for i := range breadcrumbs {
+		breadcrumbs[i] = fmt.Sprintf(`{"message":"step %d"}`, i)
+	}
+	payload := []byte(`{"title":"TypeError","description":"` + strings.Repeat("a", 10000) + `",` +
+		`"breadcrumbs":[` + strings.Join(breadcrumbs, ",") + `],"backtrace":[{"file":"app.js","line":1}]}`)
+
+	truncated, ok := Truncate(payload, 5000)
+	assert.True(t, ok)
+	assert.LessOrEqual(t, len(truncated), 5000)
+	assert.True(t, gjson.ValidBytes(truncated))
+
+	fields := gjson.GetManyBytes(truncated, "title", "description", "breadcrumbs.#", "breadcrumbs.0.message", "backtrace.0.file", "truncated.originalSize")
+	assert.Equal(t, "TypeError", fields[0].String())
+	assert.True(t, strings.HasSuffix(fields[1].String(), "…"))
+	assert.Less(t, len(fields[1].String()), 10000)
+	assert.Less(t, fields[2].Int(), int64(200))
+
+	// the latest breadcrumbs are kept
+	assert.NotEqual(t, "step 0", fields[3].String())
+	assert.Equal(t, "app.js", fields[4].String())
+	assert.Equal(t, int64(len(payload)), fields[5].Int())
+}
+
+func TestTruncateDropsObjects(t *testing.T) {
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf(`"key%d":%d`, i, i)
+	}
+	payload := []byte(`{"title":"Error","context":{` + strings.Join(keys, ",") + `}}`)
+
+	truncated, ok := Truncate(payload, 200)
+	assert.True(t, ok)
+	assert.Equal(t, fmt.Sprintf(`{"title":"Error","context":"[Truncated]","truncated":{"originalSize":%d}}`, len(payload)), string(truncated))
+}
+
+func TestTruncateFails(t *testing.T) {
+	payload := []byte(`{"title":"` + strings.Repeat("a", 100) + `"}`)
+
+	_, ok := Truncate(payload, 50)
+	assert.False(t, ok)
+
+	_, ok = Truncate([]byte(`"`+strings.Repeat("a", 100)+`"`), 50)
+	assert.False(t, ok)
+
+	fits, ok := Truncate(payload, 1000)
+	assert.True(t, ok)
+	assert.Equal(t, payload, fits)
+}
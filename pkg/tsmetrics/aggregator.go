@@ -0,0 +1,174 @@
+package tsmetrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/codex-team/hawk.collector/pkg/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxFlushAttempts is the number of flushes a sample is kept for if Redis keeps rejecting it
+const maxFlushAttempts = 10
+
+// sample identifies a single bucket of the time series
+type sample struct {
+	key       string
+	timestamp int64
+}
+
+// Aggregator accumulates time series samples in memory and periodically writes them to Redis,
+// so requests don't wait for Redis TimeSeries writes
+type Aggregator struct {
+	redisClient *redis.RedisClient
+
+	// Retention of minutely, hourly and daily series
+	Retention Retention
+
+	// Compaction writes only minutely series compacted into hourly and daily ones by Redis
+	Compaction bool
+
+	mx     sync.Mutex
+	counts map[sample]int64
+	series map[string]redis.TSKey
+
+	// flushMx serializes flushes, known and attempts are accessed only while flushing
+	flushMx sync.Mutex
+	known   map[string]struct{}
+
+	// attempts is the number of failed flushes of samples restored for the next flush
+	attempts map[sample]int
+}
+
+// New creates aggregator writing samples with the Redis client
+func New(redisClient *redis.RedisClient, retention Retention, compaction bool) *Aggregator {
+	return &Aggregator{
+		redisClient: redisClient,
+		Retention:   retention,
+		Compaction:  compaction,
+		counts:      make(map[sample]int64),
+		series:      make(map[string]redis.TSKey),
+		known:       make(map[string]struct{}),
+		attempts:    make(map[sample]int),
+	}
+}
+
+// Count adds value to the current buckets of minutely, hourly and daily series
+func (a *Aggregator) Count(minutelyKey, hourlyKey, dailyKey string, labels map[string]string, value int64) {
+	now := time.Now()
+	if a.Compaction {
+		// hourly and daily series are computed by compaction rules created along with the minutely one
+		a.Add(a.Retention.Compacted(minutelyKey, hourlyKey, dailyKey, labels), bucketTimestampMs(now, time.Minute), value)
+		return
+	}
+
+	a.Add(redis.TSKey{Key: minutelyKey, Labels: labels, Retention: a.Retention.Minutely}, bucketTimestampMs(now, time.Minute), value)
+	a.Add(redis.TSKey{Key: hourlyKey, Labels: labels, Retention: a.Retention.Hourly}, bucketTimestampMs(now, time.Hour), value)
+	a.Add(redis.TSKey{Key: dailyKey, Labels: labels, Retention: a.Retention.Daily}, bucketTimestampMs(now, 24*time.Hour), value)
+}
+
+// bucketTimestampMs returns the time truncated to the start of the UTC bucket, in milliseconds.
+// Truncating ensures that all events within the same bucket share one timestamp so DUPLICATE_POLICY SUM
+// accumulates them into a single sample instead of creating a separate sample per event.
+func bucketTimestampMs(now time.Time, bucket time.Duration) int64 {
+	return now.UTC().Truncate(bucket).UnixNano() / int64(time.Millisecond)
+}
+
+// Add adds value to the bucket of the time series starting at timestamp in milliseconds.
+// The key is created with labels and retention on the first flush.
+func (a *Aggregator) Add(series redis.TSKey, timestamp, value int64) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	a.counts[sample{key: series.Key, timestamp: timestamp}] += value
+	if _, ok := a.series[series.Key]; !ok {
+		a.series[series.Key] = series
+	}
+}
+
+// Pending returns values accumulated since the last flush summed per key
+func (a *Aggregator) Pending() map[string]int64 {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	pending := make(map[string]int64, len(a.series))
+	for s, value := range a.counts {
+		pending[s.key] += value
+	}
+	return pending
+}
+
+// Flush writes accumulated samples with TS.MADD, creating unknown keys first.
+// Samples which failed to be written are kept until the next flush, up to maxFlushAttempts times.
+func (a *Aggregator) Flush() error {
+	a.flushMx.Lock()
+	defer a.flushMx.Unlock()
+
+	samples, series := a.take()
+	if len(samples) == 0 {
+		return nil
+	}
+
+	var created []redis.TSKey
+	for key, s := range series {
+		if _, ok := a.known[key]; !ok {
+			created = append(created, s)
+		}
+	}
+	if len(created) > 0 {
+		if err := a.redisClient.TSCreateAll(created); err != nil {
+			a.restore(samples, series)
+			return err
+		}
+		for _, s := range created {
+			a.known[s.Key] = struct{}{}
+		}
+	}
+
+	failed, err := a.redisClient.TSMAdd(samples)
+	a.restore(failed, series)
+	if len(failed) > 0 {
+		// keys could be deleted since they were created, so create them again on the next flush
+		for _, s := range failed {
+			delete(a.known, s.Key)
+		}
+		log.Warnf("Failed to write %d of %d time series samples: %s", len(failed), len(samples), err)
+	}
+	return err
+}
+
+// take returns accumulated samples and their series and resets them
+func (a *Aggregator) take() ([]redis.TSKeySample, map[string]redis.TSKey) {
+	a.mx.Lock()
+	counts, series := a.counts, a.series
+	a.counts = make(map[sample]int64, len(counts))
+	a.series = make(map[string]redis.TSKey, len(series))
+	a.mx.Unlock()
+
+	samples := make([]redis.TSKeySample, 0, len(counts))
+	for s, value := range counts {
+		samples = append(samples, redis.TSKeySample{Key: s.key, Timestamp: s.timestamp, Value: value})
+	}
+	return samples, series
+}
+
+// restore returns samples which were not written back to be flushed again.
+// Samples which failed maxFlushAttempts times are dropped, so they don't pile up while Redis rejects them.
+func (a *Aggregator) restore(samples []redis.TSKeySample, series map[string]redis.TSKey) {
+	attempts := make(map[sample]int, len(samples))
+	dropped := 0
+	for _, s := range samples {
+		bucket := sample{key: s.Key, timestamp: s.Timestamp}
+		if a.attempts[bucket]+1 >= maxFlushAttempts {
+			dropped++
+			continue
+		}
+		attempts[bucket] = a.attempts[bucket] + 1
+		a.Add(series[s.Key], s.Timestamp, s.Value)
+	}
+	a.attempts = attempts
+
+	if dropped > 0 {
+		log.Errorf("Dropped %d time series samples after %d failed flushes", dropped, maxFlushAttempts)
+	}
+}
@@ -0,0 +1,105 @@
+package tsmetrics
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/codex-team/hawk.collector/pkg/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregatorTake(t *testing.T) {
+	aggregator := New(nil, Retention{}, false)
+
+	minutely := redis.TSKey{Key: "ts:project-events-accepted:p1:minutely", Labels: map[string]string{"project": "p1"}, Retention: 24 * time.Hour}
+	daily := redis.TSKey{Key: "ts:project-events-accepted:p1:daily", Retention: 90 * 24 * time.Hour}
+
+	aggregator.Add(minutely, 60000, 1)
+	aggregator.Add(minutely, 60000, 1)
+	aggregator.Add(minutely, 120000, 1)
+	aggregator.Add(daily, 0, 3)
+
+	samples, series := aggregator.take()
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].Key != samples[j].Key {
+			return samples[i].Key < samples[j].Key
+		}
+		return samples[i].Timestamp < samples[j].Timestamp
+	})
+	assert.Equal(t, []redis.TSKeySample{
+		{Key: daily.Key, Timestamp: 0, Value: 3},
+		{Key: minutely.Key, Timestamp: 60000, Value: 2},
+		{Key: minutely.Key, Timestamp: 120000, Value: 1},
+	}, samples)
+	assert.Equal(t, map[string]redis.TSKey{minutely.Key: minutely, daily.Key: daily}, series)
+
+	// samples are taken once
+	samples, _ = aggregator.take()
+	assert.Empty(t, samples)
+	assert.NoError(t, aggregator.Flush())
+
+	// failed samples are merged with new ones
+	aggregator.restore([]redis.TSKeySample{{Key: minutely.Key, Timestamp: 60000, Value: 2}}, series)
+	aggregator.Add(minutely, 60000, 1)
+	samples, series = aggregator.take()
+	assert.Equal(t, []redis.TSKeySample{{Key: minutely.Key, Timestamp: 60000, Value: 3}}, samples)
+	assert.Equal(t, minutely, series[minutely.Key])
+}
+
+func TestAggregatorRestoreAttempts(t *testing.T) {
+	aggregator := New(nil, Retention{}, false)
+
+	minutely := redis.TSKey{Key: "ts:project-events-accepted:p1:minutely"}
+	series := map[string]redis.TSKey{minutely.Key: minutely}
+	failed := []redis.TSKeySample{{Key: minutely.Key, Timestamp: 60000, Value: 2}}
+
+	// samples rejected by every flush are kept for maxFlushAttempts flushes
+	for i := 1; i < maxFlushAttempts; i++ {
+		aggregator.restore(failed, series)
+		samples, _ := aggregator.take()
+		assert.Equal(t, failed, samples, "attempt %d", i)
+	}
+	aggregator.restore(failed, series)
+	samples, _ := aggregator.take()
+	assert.Empty(t, samples)
+	assert.Empty(t, aggregator.attempts)
+
+	// attempts are reset once the sample is written
+	aggregator.restore(failed, series)
+	aggregator.restore(nil, series)
+	assert.Empty(t, aggregator.attempts)
+}
+
+func TestAggregatorCount(t *testing.T) {
+	retention := Retention{Minutely: time.Hour, Hourly: 2 * time.Hour, Daily: 3 * time.Hour}
+	labels := map[string]string{"project": "p1"}
+
+	aggregator := New(nil, retention, false)
+	aggregator.Count("m", "h", "d", labels, 10)
+	aggregator.Count("m", "h", "d", labels, 5)
+	assert.Equal(t, map[string]int64{"m": 15, "h": 15, "d": 15}, aggregator.Pending())
+	samples, series := aggregator.take()
+	assert.Len(t, samples, 3)
+	for _, s := range samples {
+		assert.Equal(t, int64(15), s.Value)
+	}
+	assert.Equal(t, redis.TSKey{Key: "h", Labels: labels, Retention: 2 * time.Hour}, series["h"])
+	assert.Empty(t, series["m"].Rules)
+
+	aggregator = New(nil, retention, true)
+	aggregator.Count("m", "h", "d", labels, 10)
+	samples, series = aggregator.take()
+	assert.Len(t, samples, 1)
+	assert.Equal(t, "m", samples[0].Key)
+	assert.Equal(t, retention.Compacted("m", "h", "d", labels), series["m"])
+}
+
+func TestBucketTimestampMs(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 30, 45, 0, time.FixedZone("UTC+3", 3*60*60))
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, day.Add(9*time.Hour+30*time.Minute).UnixNano()/int64(time.Millisecond), bucketTimestampMs(now, time.Minute))
+	assert.Equal(t, day.Add(9*time.Hour).UnixNano()/int64(time.Millisecond), bucketTimestampMs(now, time.Hour))
+	assert.Equal(t, day.UnixNano()/int64(time.Millisecond), bucketTimestampMs(now, 24*time.Hour))
+}
@@ -0,0 +1,20 @@
+package tsmetrics
+
+import "fmt"
+
+// ProjectKey generates a Redis TimeSeries key for project metrics
+func ProjectKey(projectId, metricType, granularity string, isSystemMetric bool) string {
+	// flag determines which counter would be incremented
+	if isSystemMetric {
+		// ts:collector-project-%s:%s:%s could be used in admin
+		return fmt.Sprintf("ts:collector-project-%s:%s:%s", metricType, projectId, granularity)
+	}
+
+	// ts:project-%s:%s:%s is used in api for chart retrieving
+	return fmt.Sprintf("ts:project-%s:%s:%s", metricType, projectId, granularity)
+}
+
+// WorkspaceKey generates a Redis TimeSeries key for workspace metrics
+func WorkspaceKey(workspaceId, metricType, granularity string) string {
+	return fmt.Sprintf("ts:workspace-%s:%s:%s", metricType, workspaceId, granularity)
+}
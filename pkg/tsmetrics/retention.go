@@ -0,0 +1,28 @@
+package tsmetrics
+
+import (
+	"time"
+
+	"github.com/codex-team/hawk.collector/pkg/redis"
+)
+
+// Retention is the time samples of metrics series are kept per granularity
+type Retention struct {
+	Minutely time.Duration
+	Hourly   time.Duration
+	Daily    time.Duration
+}
+
+// Compacted returns the minutely series with SUM compaction rules into the hourly and daily series.
+// Destinations without labels get labels of the minutely series.
+func (r Retention) Compacted(minutelyKey, hourlyKey, dailyKey string, labels map[string]string) redis.TSKey {
+	return redis.TSKey{
+		Key:       minutelyKey,
+		Labels:    labels,
+		Retention: r.Minutely,
+		Rules: []redis.TSRule{
+			{Destination: redis.TSKey{Key: hourlyKey, Labels: labels, Retention: r.Hourly}, Bucket: time.Hour},
+			{Destination: redis.TSKey{Key: dailyKey, Labels: labels, Retention: r.Daily}, Bucket: 24 * time.Hour},
+		},
+	}
+}
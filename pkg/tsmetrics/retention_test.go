@@ -0,0 +1,25 @@
+package tsmetrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/codex-team/hawk.collector/pkg/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetentionCompacted(t *testing.T) {
+	retention := Retention{Minutely: 24 * time.Hour, Hourly: 7 * 24 * time.Hour, Daily: 90 * 24 * time.Hour}
+	labels := map[string]string{"project": "p1"}
+
+	series := retention.Compacted("ts:m:p1:minutely", "ts:m:p1:hourly", "ts:m:p1:daily", labels)
+	assert.Equal(t, redis.TSKey{
+		Key:       "ts:m:p1:minutely",
+		Labels:    labels,
+		Retention: 24 * time.Hour,
+		Rules: []redis.TSRule{
+			{Destination: redis.TSKey{Key: "ts:m:p1:hourly", Labels: labels, Retention: 7 * 24 * time.Hour}, Bucket: time.Hour},
+			{Destination: redis.TSKey{Key: "ts:m:p1:daily", Labels: labels, Retention: 90 * 24 * time.Hour}, Bucket: 24 * time.Hour},
+		},
+	}, series)
+}